@@ -13,6 +13,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
@@ -21,6 +22,27 @@ import (
 
 var serviceIDPattern = regexp.MustCompile(`^(.+?):([a-zA-Z0-9][a-zA-Z0-9_.-]+):[0-9]+(?::udp)?$`)
 
+// Attrs adapters can store with a service and return from Services() so that
+// cleanup can tell which host and container own it regardless of its ID.
+const (
+	OwnerHostAttr      = "registrator-host"
+	OwnerContainerAttr = "registrator-container"
+)
+
+// serviceOwner returns the hostname and container name that registered an
+// external service, preferring the ownership attrs over parsing the ID.
+func serviceOwner(service *Service) (string, string, bool) {
+	host, container := service.Attrs[OwnerHostAttr], service.Attrs[OwnerContainerAttr]
+	if host != "" && container != "" {
+		return host, container, true
+	}
+	matches := serviceIDPattern.FindStringSubmatch(service.ID)
+	if len(matches) != 3 {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
 type Bridge struct {
 	sync.Mutex
 	registry       RegistryAdapter
@@ -28,6 +50,8 @@ type Bridge struct {
 	services       map[string][]*Service
 	deadContainers map[string]*DeadContainer
 	config         Config
+	// health is the registry when it reports container health.
+	health HealthAware
 }
 
 func New(docker *client.Client, adapterUri string, config Config) (*Bridge, error) {
@@ -41,12 +65,15 @@ func New(docker *client.Client, adapterUri string, config Config) (*Bridge, erro
 	}
 
 	log.Info("Using adapter", "scheme", uri.Scheme, "uri", uri)
+	registry := factory.New(uri)
+	health, _ := registry.(HealthAware)
 	return &Bridge{
 		docker:         docker,
 		config:         config,
-		registry:       factory.New(uri),
+		registry:       registry,
 		services:       make(map[string][]*Service),
 		deadContainers: make(map[string]*DeadContainer),
+		health:         health,
 	}, nil
 }
 
@@ -69,6 +96,15 @@ func (b *Bridge) RemoveOnExit(containerId string) {
 }
 
 func (b *Bridge) Refresh() {
+	b.Lock()
+	containerIds := make([]string, 0, len(b.services))
+	for containerId := range b.services {
+		containerIds = append(containerIds, containerId)
+	}
+	containerIds = b.healthContainers(containerIds)
+	b.Unlock()
+	containers := b.inspect(containerIds...)
+
 	b.Lock()
 	defer b.Unlock()
 
@@ -80,6 +116,7 @@ func (b *Bridge) Refresh() {
 	}
 
 	for containerId, services := range b.services {
+		setContainer(services, containers[containerId])
 		for _, service := range services {
 			err := b.registry.Refresh(service)
 			if err != nil {
@@ -91,10 +128,81 @@ func (b *Bridge) Refresh() {
 	}
 }
 
-func (b *Bridge) Sync(quiet bool) {
+// UpdateHealth refreshes the services of a container after Docker reported a
+// change of its health status.
+func (b *Bridge) UpdateHealth(containerId string) {
+	b.Lock()
+	containerIds := b.healthContainers([]string{containerId})
+	b.Unlock()
+	if len(containerIds) == 0 {
+		return
+	}
+	containers := b.inspect(containerIds...)
+
 	b.Lock()
 	defer b.Unlock()
 
+	services := b.services[containerId]
+	if services == nil {
+		return
+	}
+	setContainer(services, containers[containerId])
+	for _, service := range services {
+		err := b.registry.Refresh(service)
+		if err != nil {
+			log.Error("health update failed", "serviceID", service.ID, "error", err)
+			continue
+		}
+		log.Debug("updated service health", "containerID", containerId[:12], "serviceID", service.ID)
+	}
+}
+
+// healthContainers returns the containers among containerIds with a service
+// whose registration depends on the container health. The caller holds the
+// bridge lock.
+func (b *Bridge) healthContainers(containerIds []string) []string {
+	needed := make([]string, 0)
+	if b.health == nil {
+		return needed
+	}
+	for _, containerId := range containerIds {
+		for _, service := range b.services[containerId] {
+			if b.health.NeedsHealth(service) {
+				needed = append(needed, containerId)
+				break
+			}
+		}
+	}
+	return needed
+}
+
+// inspect returns the current state of the containers, such as their
+// health. It does not need the bridge lock.
+func (b *Bridge) inspect(containerIds ...string) map[string]*types.ContainerJSON {
+	containers := make(map[string]*types.ContainerJSON, len(containerIds))
+	for _, containerId := range containerIds {
+		container, err := b.docker.ContainerInspect(context.Background(), containerId)
+		if err != nil {
+			log.Error("unable to inspect container", "containerID", containerId[:12], "error", err)
+			continue
+		}
+		containers[containerId] = &container
+	}
+	return containers
+}
+
+// setContainer updates the container state seen by the adapters through the
+// services' Origin. A nil container leaves the last one in place.
+func setContainer(services []*Service, container *types.ContainerJSON) {
+	if container == nil {
+		return
+	}
+	for _, service := range services {
+		service.Origin.container = container
+	}
+}
+
+func (b *Bridge) Sync(quiet bool) {
 	ctx := context.Background()
 	containers, err := b.docker.ContainerList(ctx, container.ListOptions{})
 	if err != nil && quiet {
@@ -107,11 +215,24 @@ func (b *Bridge) Sync(quiet bool) {
 
 	log.Info("Syncing services", "containerCount", len(containers))
 
+	b.Lock()
+	containerIds := make([]string, 0, len(containers))
+	for _, listing := range containers {
+		containerIds = append(containerIds, listing.ID)
+	}
+	containerIds = b.healthContainers(containerIds)
+	b.Unlock()
+	inspected := b.inspect(containerIds...)
+
+	b.Lock()
+	defer b.Unlock()
+
 	for _, listing := range containers {
 		services := b.services[listing.ID]
 		if services == nil {
 			b.add(listing.ID, quiet)
 		} else {
+			setContainer(services, inspected[listing.ID])
 			for _, service := range services {
 				err := b.registry.Register(service)
 				if err != nil {
@@ -156,15 +277,10 @@ func (b *Bridge) Sync(quiet bool) {
 
 	Outer:
 		for _, extService := range extServices {
-			matches := serviceIDPattern.FindStringSubmatch(extService.ID)
-			if len(matches) != 3 {
-				continue
-			}
-			serviceHostname := matches[1]
-			if serviceHostname != Hostname {
+			serviceHostname, serviceContainerName, ok := serviceOwner(extService)
+			if !ok || serviceHostname != Hostname {
 				continue
 			}
-			serviceContainerName := matches[2]
 			for _, listing := range b.services {
 				for _, service := range listing {
 					if service.Name == extService.Name && serviceContainerName == service.Origin.container.Name[1:] {
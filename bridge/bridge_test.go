@@ -1,8 +1,14 @@
 package bridge
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +27,105 @@ func TestNewValid(t *testing.T) {
 	assert.NotNil(t, bridge)
 	assert.NoError(t, err)
 }
+
+func TestServiceOwner(t *testing.T) {
+	host, container, ok := serviceOwner(&Service{ID: "node1:web:80"})
+	assert.True(t, ok)
+	assert.Equal(t, "node1", host)
+	assert.Equal(t, "web", container)
+
+	host, container, ok = serviceOwner(&Service{
+		ID:    "custom-id",
+		Attrs: map[string]string{OwnerHostAttr: "node2", OwnerContainerAttr: "api"},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "node2", host)
+	assert.Equal(t, "api", container)
+
+	_, _, ok = serviceOwner(&Service{ID: "custom-id"})
+	assert.False(t, ok)
+}
+
+// healthAdapter records the container health seen by Register and Refresh.
+type healthAdapter struct {
+	fakeAdapter
+	needs  bool
+	health []*types.Health
+}
+
+func (a *healthAdapter) NeedsHealth(service *Service) bool {
+	return a.needs
+}
+
+func (a *healthAdapter) Register(service *Service) error {
+	a.health = append(a.health, service.Origin.Health())
+	return nil
+}
+
+func (a *healthAdapter) Refresh(service *Service) error {
+	a.health = append(a.health, service.Origin.Health())
+	return nil
+}
+
+// newInspectServer fakes the Docker container list and inspect endpoints,
+// counting inspects.
+func newInspectServer(t *testing.T, inspects *atomic.Int32) *client.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(req.URL.Path, "/containers/json") {
+			w.Write([]byte(`[{"Id":"0123456789abcdef","Names":["/web"]}]`))
+			return
+		}
+		if !strings.HasSuffix(req.URL.Path, "/json") {
+			http.NotFound(w, req)
+			return
+		}
+		inspects.Add(1)
+		w.Write([]byte(`{"Id":"0123456789abcdef","Name":"/web","State":{"Running":true,"Health":{"Status":"unhealthy"}}}`))
+	}))
+	t.Cleanup(server.Close)
+	docker, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.43"))
+	assert.NoError(t, err)
+	return docker
+}
+
+func TestRefreshHealth(t *testing.T) {
+	unhealthy := &types.Health{Status: types.Unhealthy}
+	tests := []struct {
+		name     string
+		needs    bool
+		inspects int32
+		// health is what Refresh, UpdateHealth and Sync saw; UpdateHealth
+		// is a no-op for services that do not need health.
+		health []*types.Health
+	}{
+		{name: "needs health", needs: true, inspects: 3, health: []*types.Health{unhealthy, unhealthy, unhealthy}},
+		{name: "no health checks", needs: false, inspects: 0, health: []*types.Health{nil, nil}},
+	}
+
+	for _, tt := range tests {
+		inspects := new(atomic.Int32)
+		registry := &healthAdapter{needs: tt.needs}
+		b := &Bridge{
+			registry:       registry,
+			docker:         newInspectServer(t, inspects),
+			services:       map[string][]*Service{"0123456789abcdef": {{ID: "web"}}},
+			deadContainers: make(map[string]*DeadContainer),
+			health:         registry,
+		}
+
+		b.Refresh()
+		b.UpdateHealth("0123456789abcdef")
+		b.Sync(false)
+
+		assert.Equal(t, tt.inspects, inspects.Load(), tt.name)
+		assert.Equal(t, tt.health, registry.health, tt.name)
+	}
+}
+
+func TestNewHealthAware(t *testing.T) {
+	Register(new(fakeFactory), "fake")
+	b, err := New(nil, "fake://", Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, b.health)
+}
@@ -18,6 +18,14 @@ type RegistryAdapter interface {
 	Services() ([]*Service, error)
 }
 
+// HealthAware is implemented by adapters that report the Docker health of
+// containers through Service.Origin.Health. The bridge inspects a container
+// again before refreshing its services only if NeedsHealth is true for one
+// of them.
+type HealthAware interface {
+	NeedsHealth(service *Service) bool
+}
+
 type Config struct {
 	HostIp          string
 	Internal        bool
@@ -58,3 +66,12 @@ type ServicePort struct {
 	ContainerName       string
 	container           *types.ContainerJSON
 }
+
+// Health returns the Docker health state of the container as of its last
+// inspect, or nil when the container has no HEALTHCHECK.
+func (p ServicePort) Health() *types.Health {
+	if p.container == nil || p.container.State == nil {
+		return nil
+	}
+	return p.container.State.Health
+}
@@ -128,6 +128,7 @@ func servicePort(containerJSON types.ContainerJSON, port nat.Port, portBindings
 		ExposedPortProtocol: exposedPortProtocol,
 		ContainerID:         containerJSON.ID,
 		ContainerHostname:   containerJSON.Config.Hostname,
+		ContainerName:       strings.TrimPrefix(containerJSON.Name, "/"),
 		container:           &containerJSON,
 	}
 }
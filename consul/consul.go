@@ -4,17 +4,31 @@ import (
 	"fmt"
 	"github.com/hashicorp/go-cleanhttp"
 	log "log/slog"
+	"net"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/docker/docker/api/types"
 	consul "github.com/hashicorp/consul/api"
 	"github.com/quangnguyen/registrator/bridge"
 )
 
 const DefaultInterval = "10s"
 
+// DefaultDockerHealthTTL is the TTL of checks mirroring the Docker HEALTHCHECK.
+// Docker only reports health changes, so registrator needs -ttl-refresh to keep them alive.
+const DefaultDockerHealthTTL = "1m"
+
+// ownedFilter selects the agent services carrying registrator's ownership marker.
+var ownedFilter = `"` + bridge.OwnerHostAttr + `" in Meta`
+
+var checkIndexPattern = regexp.MustCompile(`^check_([0-9]+)_`)
+
 func init() {
 	f := new(Factory)
 	bridge.Register(f, "consul")
@@ -54,15 +68,48 @@ func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
 	} else if uri.Host != "" {
 		config.Address = uri.Host
 	}
+	query := uri.Query()
+	if token := query.Get("token"); token != "" {
+		config.Token = token
+	}
+	if dc := query.Get("dc"); dc != "" {
+		config.Datacenter = dc
+	}
+	if partition := query.Get("partition"); partition != "" {
+		config.Partition = partition
+	}
+	// The namespace is sent in each registration body instead of as a query
+	// parameter, so that containers can override it with SERVICE_NAMESPACE.
+	namespace := config.Namespace
+	if ns := query.Get("ns"); ns != "" {
+		namespace = ns
+	}
+	config.Namespace = ""
 	client, err := consul.NewClient(config)
 	if err != nil {
 		log.Error("consul: ", "scheme", uri.Scheme, "error", err)
 	}
-	return &Consul{client: client}
+	return &Consul{
+		client:     client,
+		namespace:  namespace,
+		namespaces: map[string]bool{namespace: true},
+	}
 }
 
 type Consul struct {
-	client *consul.Client
+	client    *consul.Client
+	namespace string
+
+	sync.Mutex
+	namespaces map[string]bool
+}
+
+// serviceNamespace returns the namespace a service is registered in.
+func (r *Consul) serviceNamespace(service *bridge.Service) string {
+	if ns := service.Attrs["namespace"]; ns != "" {
+		return ns
+	}
+	return r.namespace
 }
 
 // Ping will try to connect to consul by attempting to retrieve the current leader.
@@ -79,15 +126,27 @@ func (r *Consul) Ping() error {
 
 func (r *Consul) Register(service *bridge.Service) error {
 	registration := consul.AgentServiceRegistration{
-		ID:      service.ID,
-		Name:    service.Name,
-		Port:    service.Port,
-		Tags:    service.Tags,
-		Address: service.IP,
-		Check:   r.buildCheck(service),
-		Meta:    service.Attrs,
+		ID:        service.ID,
+		Name:      service.Name,
+		Port:      service.Port,
+		Tags:      service.Tags,
+		Address:   service.IP,
+		Check:     r.buildCheck(service, "check"),
+		Checks:    r.buildChecks(service),
+		Connect:   r.buildConnect(service),
+		Meta:      r.buildMeta(service),
+		Namespace: r.serviceNamespace(service),
+
+		Weights:           buildWeights(service),
+		TaggedAddresses:   buildTaggedAddresses(service),
+		EnableTagOverride: enableTagOverride(service),
+		SocketPath:        service.Attrs["socket_path"],
 	}
 
+	r.Lock()
+	r.namespaces[registration.Namespace] = true
+	r.Unlock()
+
 	opts := consul.ServiceRegisterOpts{
 		ReplaceExistingChecks: true,
 	}
@@ -95,90 +154,423 @@ func (r *Consul) Register(service *bridge.Service) error {
 	return r.client.Agent().ServiceRegisterOpts(&registration, opts)
 }
 
-func (r *Consul) buildCheck(service *bridge.Service) *consul.AgentServiceCheck {
+// buildCheck builds the check described by the attrs starting with prefix,
+// e.g. "check" for SERVICE_CHECK_HTTP or "check_1" for SERVICE_CHECK_1_HTTP.
+func (r *Consul) buildCheck(service *bridge.Service, prefix string) *consul.AgentServiceCheck {
+	attr := func(name string) string {
+		return service.Attrs[prefix+"_"+name]
+	}
+	enabled := func(name string) bool {
+		value, _ := strconv.ParseBool(attr(name))
+		return value
+	}
 	check := new(consul.AgentServiceCheck)
-	if status := service.Attrs["check_initial_status"]; status != "" {
+	if name := attr("name"); name != "" {
+		check.Name = name
+	}
+	if status := attr("initial_status"); status != "" {
 		check.Status = status
 	}
-	if path := service.Attrs["check_http"]; path != "" {
+	if path := attr("http"); path != "" {
 		check.HTTP = fmt.Sprintf("http://%s:%d%s", service.IP, service.Port, path)
-		if timeout := service.Attrs["check_timeout"]; timeout != "" {
+		if timeout := attr("timeout"); timeout != "" {
 			check.Timeout = timeout
 		}
-		if method := service.Attrs["check_http_method"]; method != "" {
+		if method := attr("http_method"); method != "" {
 			check.Method = method
 		}
-	} else if path := service.Attrs["check_https"]; path != "" {
+		check.Header = parseHeaders(attr("http_headers"))
+		check.Body = attr("http_body")
+	} else if path := attr("https"); path != "" {
 		check.HTTP = fmt.Sprintf("https://%s:%d%s", service.IP, service.Port, path)
-		if timeout := service.Attrs["check_timeout"]; timeout != "" {
+		if timeout := attr("timeout"); timeout != "" {
 			check.Timeout = timeout
 		}
-		if method := service.Attrs["check_https_method"]; method != "" {
+		if method := attr("https_method"); method != "" {
 			check.Method = method
 		}
-	} else if cmd := service.Attrs["check_cmd"]; cmd != "" {
+		check.Header = parseHeaders(attr("http_headers"))
+		check.Body = attr("http_body")
+		check.TLSServerName = attr("tls_server_name")
+		check.TLSSkipVerify = enabled("tls_skip_verify")
+	} else if enabled("docker_health") {
+		check.CheckID = ttlCheckID(service, prefix)
+		check.TTL = attr("docker_health_ttl")
+		if check.TTL == "" {
+			check.TTL = DefaultDockerHealthTTL
+		}
+		if check.Status == "" {
+			check.Status, _ = healthStatus(service.Origin.Health())
+		}
+	} else if cmd := attr("docker"); cmd != "" {
+		shell := attr("docker_shell")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		check.DockerContainerID = service.Origin.ContainerID
+		check.Shell = shell
+		check.Args = []string{shell, "-c", cmd}
+	} else if cmd := attr("cmd"); cmd != "" {
 		check.Args = []string{"check-cmd", service.Origin.ContainerID[:12], service.Origin.ExposedPort, cmd}
-	} else if script := service.Attrs["check_script"]; script != "" {
+	} else if script := attr("script"); script != "" {
 		check.Args = []string{r.interpolateService(script, service)}
-	} else if ttl := service.Attrs["check_ttl"]; ttl != "" {
+	} else if ttl := attr("ttl"); ttl != "" {
+		check.CheckID = ttlCheckID(service, prefix)
 		check.TTL = ttl
-	} else if tcp := service.Attrs["check_tcp"]; tcp != "" {
+	} else if tcp := attr("tcp"); tcp != "" {
 		check.TCP = fmt.Sprintf("%s:%d", service.IP, service.Port)
-		if timeout := service.Attrs["check_timeout"]; timeout != "" {
+		if timeout := attr("timeout"); timeout != "" {
+			check.Timeout = timeout
+		}
+	} else if udp := attr("udp"); udp != "" {
+		check.UDP = fmt.Sprintf("%s:%d", service.IP, service.Port)
+		if timeout := attr("timeout"); timeout != "" {
 			check.Timeout = timeout
 		}
-	} else if grpc := service.Attrs["check_grpc"]; grpc != "" {
+	} else if h2ping := attr("h2ping"); h2ping != "" {
+		check.H2PING = fmt.Sprintf("%s:%d", service.IP, service.Port)
+		if timeout := attr("timeout"); timeout != "" {
+			check.Timeout = timeout
+		}
+		if enabled("h2ping_use_tls") {
+			check.H2PingUseTLS = true
+			check.TLSServerName = attr("tls_server_name")
+			check.TLSSkipVerify = enabled("tls_skip_verify")
+		}
+	} else if alias := attr("alias"); alias != "" {
+		check.AliasService = alias
+	} else if grpc := attr("grpc"); grpc != "" {
 		check.GRPC = fmt.Sprintf("%s:%d", service.IP, service.Port)
-		if timeout := service.Attrs["check_timeout"]; timeout != "" {
+		if timeout := attr("timeout"); timeout != "" {
 			check.Timeout = timeout
 		}
-		if useTLS := service.Attrs["check_grpc_use_tls"]; useTLS != "" {
+		if enabled("grpc_use_tls") {
 			check.GRPCUseTLS = true
-			if tlsSkipVerify := service.Attrs["check_tls_skip_verify"]; tlsSkipVerify != "" {
-				check.TLSSkipVerify = true
-			}
+			check.TLSSkipVerify = enabled("tls_skip_verify")
 		}
 	} else {
 		return nil
 	}
-	if len(check.Args) != 0 || check.HTTP != "" || check.TCP != "" || check.GRPC != "" {
-		if interval := service.Attrs["check_interval"]; interval != "" {
+	if len(check.Args) != 0 || check.HTTP != "" || check.TCP != "" || check.GRPC != "" || check.UDP != "" || check.H2PING != "" {
+		if interval := attr("interval"); interval != "" {
 			check.Interval = interval
 		} else {
 			check.Interval = DefaultInterval
 		}
 	}
-	if deregisterAfter := service.Attrs["check_deregister_after"]; deregisterAfter != "" {
+	if deregisterAfter := attr("deregister_after"); deregisterAfter != "" {
 		check.DeregisterCriticalServiceAfter = deregisterAfter
 	}
 	return check
 }
 
+// buildMeta adds the ownership markers to the service attrs, so that cleanup
+// only ever considers services registered by registrator. Check attrs are
+// left out, since HTTP check headers may carry credentials.
+func (r *Consul) buildMeta(service *bridge.Service) map[string]string {
+	meta := make(map[string]string, len(service.Attrs)+2)
+	for k, v := range service.Attrs {
+		if k == "check" || strings.HasPrefix(k, "check_") {
+			continue
+		}
+		meta[k] = v
+	}
+	meta[bridge.OwnerHostAttr] = bridge.Hostname
+	meta[bridge.OwnerContainerAttr] = service.Origin.ContainerName
+	return meta
+}
+
+// enableTagOverride reads SERVICE_ENABLE_TAG_OVERRIDE, treating values that
+// are not booleans as false.
+func enableTagOverride(service *bridge.Service) bool {
+	enabled, _ := strconv.ParseBool(service.Attrs["enable_tag_override"])
+	return enabled
+}
+
+// buildWeights returns the DNS SRV weights for SERVICE_WEIGHT_PASSING and
+// SERVICE_WEIGHT_WARNING, using Consul's defaults for the one not set.
+func buildWeights(service *bridge.Service) *consul.AgentWeights {
+	passing, passingErr := strconv.Atoi(service.Attrs["weight_passing"])
+	warning, warningErr := strconv.Atoi(service.Attrs["weight_warning"])
+	if passingErr != nil && warningErr != nil {
+		return nil
+	}
+	weights := &consul.AgentWeights{Passing: 1, Warning: 1}
+	if passingErr == nil {
+		weights.Passing = passing
+	}
+	if warningErr == nil {
+		weights.Warning = warning
+	}
+	return weights
+}
+
+// buildTaggedAddresses returns the lan and wan addresses from
+// SERVICE_TAGGED_ADDRESS_LAN and SERVICE_TAGGED_ADDRESS_WAN. Each is either
+// "host" for the published host IP and port, "container" for the container
+// IP and exposed port, or an explicit <ip>[:<port>].
+func buildTaggedAddresses(service *bridge.Service) map[string]consul.ServiceAddress {
+	addresses := make(map[string]consul.ServiceAddress)
+	for _, tag := range []string{"lan", "wan"} {
+		value := service.Attrs["tagged_address_"+tag]
+		var address consul.ServiceAddress
+		switch value {
+		case "":
+			continue
+		case "host":
+			address.Address = service.Origin.HostIP
+			address.Port, _ = strconv.Atoi(service.Origin.HostPort)
+		case "container":
+			address.Address = service.Origin.ExposedIP
+			address.Port, _ = strconv.Atoi(service.Origin.ExposedPort)
+		default:
+			host, port, err := net.SplitHostPort(value)
+			if err != nil {
+				host, port = value, strconv.Itoa(service.Port)
+			}
+			address.Address = host
+			address.Port, _ = strconv.Atoi(port)
+		}
+		addresses[tag] = address
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	return addresses
+}
+
+// buildConnect returns the service mesh settings: SERVICE_CONNECT=native for
+// Connect-native services, or a sidecar proxy registration when
+// SERVICE_CONNECT=sidecar, SERVICE_CONNECT_SIDECAR_PORT or
+// SERVICE_CONNECT_UPSTREAMS is set.
+func (r *Consul) buildConnect(service *bridge.Service) *consul.AgentServiceConnect {
+	mode := service.Attrs["connect"]
+	if mode == "native" {
+		return &consul.AgentServiceConnect{Native: true}
+	}
+	sidecarPort := service.Attrs["connect_sidecar_port"]
+	upstreams := service.Attrs["connect_upstreams"]
+	if mode != "sidecar" && sidecarPort == "" && upstreams == "" {
+		return nil
+	}
+
+	proxy := &consul.AgentServiceConnectProxyConfig{
+		Upstreams: parseUpstreams(upstreams),
+	}
+	if localPort, err := strconv.Atoi(service.Origin.ExposedPort); err == nil {
+		proxy.LocalServicePort = localPort
+	}
+	sidecar := &consul.AgentServiceRegistration{Proxy: proxy}
+	if port, err := strconv.Atoi(sidecarPort); err == nil {
+		sidecar.Port = port
+	} else if sidecarPort != "" {
+		log.Error("consul: invalid sidecar port", "serviceID", service.ID, "port", sidecarPort)
+	}
+	return &consul.AgentServiceConnect{SidecarService: sidecar}
+}
+
+// parseUpstreams parses comma separated <name>:<local port>[:<datacenter>] entries.
+func parseUpstreams(upstreams string) []consul.Upstream {
+	if upstreams == "" {
+		return nil
+	}
+	out := make([]consul.Upstream, 0)
+	for _, entry := range strings.Split(upstreams, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || parts[0] == "" {
+			log.Error("consul: invalid upstream, expected <name>:<port>", "upstream", entry)
+			continue
+		}
+		port, err := strconv.Atoi(parts[1])
+		if err != nil {
+			log.Error("consul: invalid upstream port", "upstream", entry, "error", err)
+			continue
+		}
+		upstream := consul.Upstream{DestinationName: parts[0], LocalBindPort: port}
+		if len(parts) > 2 {
+			upstream.Datacenter = parts[2]
+		}
+		out = append(out, upstream)
+	}
+	return out
+}
+
+// parseHeaders parses "Name: value" pairs separated by semicolons into the
+// header map of an HTTP check. Repeated names add values.
+func parseHeaders(headers string) map[string][]string {
+	if headers == "" {
+		return nil
+	}
+	header := make(map[string][]string)
+	for _, pair := range strings.Split(headers, ";") {
+		name, value, found := strings.Cut(pair, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			continue
+		}
+		header[name] = append(header[name], strings.TrimSpace(value))
+	}
+	return header
+}
+
+// checkIndexes returns the indexes of the numbered SERVICE_CHECK_<n>_* checks in order.
+func checkIndexes(service *bridge.Service) []int {
+	indexes := make([]int, 0)
+	seen := make(map[int]bool)
+	for key := range service.Attrs {
+		matches := checkIndexPattern.FindStringSubmatch(key)
+		if matches == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(matches[1])
+		if !seen[index] {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// buildChecks builds the additional indexed checks, ordered by their index.
+func (r *Consul) buildChecks(service *bridge.Service) consul.AgentServiceChecks {
+	indexes := checkIndexes(service)
+	checks := make(consul.AgentServiceChecks, 0, len(indexes))
+	for _, index := range indexes {
+		if check := r.buildCheck(service, "check_"+strconv.Itoa(index)); check != nil {
+			checks = append(checks, check)
+		}
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	return checks
+}
+
 func (r *Consul) Deregister(service *bridge.Service) error {
-	return r.client.Agent().ServiceDeregister(service.ID)
+	return r.client.Agent().ServiceDeregisterOpts(service.ID, &consul.QueryOptions{Namespace: r.serviceNamespace(service)})
+}
+
+// NeedsHealth makes the bridge inspect the containers of services with TTL
+// checks before each Refresh, so that Origin.Health is current.
+func (r *Consul) NeedsHealth(service *bridge.Service) bool {
+	return len(ttlCheckIDs(service)) > 0
 }
 
-func (r *Consul) Refresh(_ *bridge.Service) error {
+// Refresh reports the container's Docker health to the service's TTL checks,
+// including those created for SERVICE_CHECK_DOCKER_HEALTH.
+func (r *Consul) Refresh(service *bridge.Service) error {
+	checkIDs := ttlCheckIDs(service)
+	if len(checkIDs) == 0 {
+		return nil
+	}
+	status, output := healthStatus(service.Origin.Health())
+	for _, checkID := range checkIDs {
+		err := r.client.Agent().UpdateTTLOpts(checkID, output, status, &consul.QueryOptions{Namespace: r.serviceNamespace(service)})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// ttlCheckID names TTL checks explicitly so Refresh can update them: the
+// unnumbered check is service:<id>, numbered ones service:<id>:ttl-<n>.
+func ttlCheckID(service *bridge.Service, prefix string) string {
+	if prefix == "check" {
+		return "service:" + service.ID
+	}
+	return "service:" + service.ID + ":ttl-" + strings.TrimPrefix(prefix, "check_")
+}
+
+func ttlCheckIDs(service *bridge.Service) []string {
+	prefixes := []string{"check"}
+	for _, index := range checkIndexes(service) {
+		prefixes = append(prefixes, "check_"+strconv.Itoa(index))
+	}
+	ids := make([]string, 0)
+	for _, prefix := range prefixes {
+		dockerHealth, _ := strconv.ParseBool(service.Attrs[prefix+"_docker_health"])
+		if service.Attrs[prefix+"_ttl"] != "" || dockerHealth {
+			ids = append(ids, ttlCheckID(service, prefix))
+		}
+	}
+	return ids
+}
+
+// healthStatus maps a Docker health state to a Consul check status. Running
+// containers without a HEALTHCHECK are passing.
+func healthStatus(health *types.Health) (string, string) {
+	if health == nil {
+		return consul.HealthPassing, "container is running"
+	}
+	output := "docker health: " + health.Status
+	if n := len(health.Log); n > 0 && health.Log[n-1].Output != "" {
+		output += "\n" + health.Log[n-1].Output
+	}
+	switch health.Status {
+	case types.Healthy:
+		return consul.HealthPassing, output
+	case types.Starting:
+		return consul.HealthWarning, output
+	}
+	return consul.HealthCritical, output
+}
+
+// listNamespaces returns the namespaces registered into since startup, plus
+// every namespace of the cluster on Consul Enterprise, so that cleanup also
+// finds services a previous run put in a SERVICE_NAMESPACE.
+func (r *Consul) listNamespaces() []string {
+	r.Lock()
+	seen := make(map[string]bool, len(r.namespaces))
+	for ns := range r.namespaces {
+		seen[ns] = true
+	}
+	r.Unlock()
+
+	// Consul CE has no namespaces endpoint and answers with an error.
+	if list, _, err := r.client.Namespaces().List(nil); err == nil {
+		for _, ns := range list {
+			seen[ns.Name] = true
+		}
+	} else {
+		log.Debug("consul: cannot list namespaces", "error", err)
+	}
+
+	namespaces := make([]string, 0, len(seen))
+	for ns := range seen {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
 func (r *Consul) Services() ([]*bridge.Service, error) {
-	services, err := r.client.Agent().Services()
-	if err != nil {
-		return []*bridge.Service{}, err
-	}
-	out := make([]*bridge.Service, len(services))
-	i := 0
-	for _, v := range services {
-		s := &bridge.Service{
-			ID:   v.ID,
-			Name: v.Service,
-			Port: v.Port,
-			Tags: v.Tags,
-			IP:   v.Address,
-		}
-		out[i] = s
-		i++
+	namespaces := r.listNamespaces()
+
+	out := make([]*bridge.Service, 0)
+	for _, ns := range namespaces {
+		services, err := r.client.Agent().ServicesWithFilterOpts(ownedFilter, &consul.QueryOptions{Namespace: ns})
+		if err != nil {
+			return []*bridge.Service{}, err
+		}
+		for _, v := range services {
+			s := &bridge.Service{
+				ID:   v.ID,
+				Name: v.Service,
+				Port: v.Port,
+				Tags: v.Tags,
+				IP:   v.Address,
+			}
+			s.Attrs = make(map[string]string, len(v.Meta)+1)
+			for k, v := range v.Meta {
+				s.Attrs[k] = v
+			}
+			if v.Namespace != "" {
+				s.Attrs["namespace"] = v.Namespace
+			}
+			out = append(out, s)
+		}
 	}
 	return out, nil
 }
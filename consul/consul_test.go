@@ -0,0 +1,335 @@
+package consul
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(attrs map[string]string) *bridge.Service {
+	return &bridge.Service{
+		ID:    "host:web:80",
+		Name:  "web",
+		IP:    "10.0.0.5",
+		Port:  8080,
+		Attrs: attrs,
+		Origin: bridge.ServicePort{
+			ContainerID: "0123456789abcdef",
+			ExposedPort: "80",
+		},
+	}
+}
+
+func TestBuildCheckNone(t *testing.T) {
+	r := &Consul{}
+	assert.Nil(t, r.buildCheck(newTestService(map[string]string{}), "check"))
+	assert.Nil(t, r.buildChecks(newTestService(map[string]string{})))
+}
+
+func TestBuildCheckHTTP(t *testing.T) {
+	r := &Consul{}
+	check := r.buildCheck(newTestService(map[string]string{
+		"check_http":     "/health",
+		"check_timeout":  "1s",
+		"check_interval": "5s",
+	}), "check")
+	assert.Equal(t, &consul.AgentServiceCheck{
+		HTTP:     "http://10.0.0.5:8080/health",
+		Timeout:  "1s",
+		Interval: "5s",
+	}, check)
+}
+
+func TestBuildChecksIndexed(t *testing.T) {
+	r := &Consul{}
+	service := newTestService(map[string]string{
+		"check_http":       "/ready",
+		"check_2_tcp":      "true",
+		"check_2_interval": "30s",
+		"check_2_name":     "liveness",
+		"check_10_ttl":     "15s",
+		"check_1_http":     "/health",
+		"check_1_timeout":  "2s",
+	})
+
+	assert.Equal(t, "http://10.0.0.5:8080/ready", r.buildCheck(service, "check").HTTP)
+	assert.Equal(t, consul.AgentServiceChecks{
+		{HTTP: "http://10.0.0.5:8080/health", Timeout: "2s", Interval: DefaultInterval},
+		{Name: "liveness", TCP: "10.0.0.5:8080", Interval: "30s"},
+		{CheckID: "service:host:web:80:ttl-10", TTL: "15s"},
+	}, r.buildChecks(service))
+}
+
+func TestBuildCheckDocker(t *testing.T) {
+	r := &Consul{}
+	check := r.buildCheck(newTestService(map[string]string{
+		"check_docker": "curl -f localhost/health",
+	}), "check")
+	assert.Equal(t, &consul.AgentServiceCheck{
+		DockerContainerID: "0123456789abcdef",
+		Shell:             "/bin/sh",
+		Args:              []string{"/bin/sh", "-c", "curl -f localhost/health"},
+		Interval:          DefaultInterval,
+	}, check)
+}
+
+func TestBuildCheckHTTPSOptions(t *testing.T) {
+	r := &Consul{}
+	check := r.buildCheck(newTestService(map[string]string{
+		"check_https":           "/health",
+		"check_http_headers":    "Authorization: Bearer x; X-Env: a; X-Env: b",
+		"check_http_body":       `{"ping":true}`,
+		"check_tls_server_name": "web.internal",
+	}), "check")
+	assert.Equal(t, "https://10.0.0.5:8080/health", check.HTTP)
+	assert.Equal(t, map[string][]string{
+		"Authorization": {"Bearer x"},
+		"X-Env":         {"a", "b"},
+	}, check.Header)
+	assert.Equal(t, `{"ping":true}`, check.Body)
+	assert.Equal(t, "web.internal", check.TLSServerName)
+}
+
+func TestBuildCheckTLSFlags(t *testing.T) {
+	tests := []struct {
+		attrs    map[string]string
+		expected *consul.AgentServiceCheck
+	}{
+		{
+			attrs:    map[string]string{"check_https": "/health", "check_tls_skip_verify": "true"},
+			expected: &consul.AgentServiceCheck{HTTP: "https://10.0.0.5:8080/health", TLSSkipVerify: true, Interval: DefaultInterval},
+		},
+		{
+			attrs:    map[string]string{"check_https": "/health", "check_tls_skip_verify": "false"},
+			expected: &consul.AgentServiceCheck{HTTP: "https://10.0.0.5:8080/health", Interval: DefaultInterval},
+		},
+		{
+			attrs:    map[string]string{"check_h2ping": "true", "check_h2ping_use_tls": "1", "check_tls_skip_verify": "true"},
+			expected: &consul.AgentServiceCheck{H2PING: "10.0.0.5:8080", H2PingUseTLS: true, TLSSkipVerify: true, Interval: DefaultInterval},
+		},
+		{
+			attrs:    map[string]string{"check_h2ping": "true", "check_h2ping_use_tls": "false", "check_tls_skip_verify": "true"},
+			expected: &consul.AgentServiceCheck{H2PING: "10.0.0.5:8080", Interval: DefaultInterval},
+		},
+		{
+			attrs:    map[string]string{"check_grpc": "true", "check_grpc_use_tls": "true", "check_tls_skip_verify": "false"},
+			expected: &consul.AgentServiceCheck{GRPC: "10.0.0.5:8080", GRPCUseTLS: true, Interval: DefaultInterval},
+		},
+		{
+			attrs:    map[string]string{"check_grpc": "true", "check_grpc_use_tls": "0"},
+			expected: &consul.AgentServiceCheck{GRPC: "10.0.0.5:8080", Interval: DefaultInterval},
+		},
+	}
+
+	r := &Consul{}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, r.buildCheck(newTestService(tt.attrs), "check"), tt.attrs)
+	}
+}
+
+func TestBuildCheckUDPAndAlias(t *testing.T) {
+	r := &Consul{}
+	udp := r.buildCheck(newTestService(map[string]string{"check_udp": "true"}), "check")
+	assert.Equal(t, &consul.AgentServiceCheck{UDP: "10.0.0.5:8080", Interval: DefaultInterval}, udp)
+
+	alias := r.buildCheck(newTestService(map[string]string{"check_alias": "db"}), "check")
+	assert.Equal(t, &consul.AgentServiceCheck{AliasService: "db"}, alias)
+}
+
+func TestBuildConnect(t *testing.T) {
+	r := &Consul{}
+	assert.Nil(t, r.buildConnect(newTestService(map[string]string{})))
+	assert.Equal(t, &consul.AgentServiceConnect{Native: true},
+		r.buildConnect(newTestService(map[string]string{"connect": "native"})))
+
+	connect := r.buildConnect(newTestService(map[string]string{
+		"connect_sidecar_port": "21000",
+		"connect_upstreams":    "db:5432, cache:6379:dc2,broken",
+	}))
+	assert.Equal(t, &consul.AgentServiceConnect{
+		SidecarService: &consul.AgentServiceRegistration{
+			Port: 21000,
+			Proxy: &consul.AgentServiceConnectProxyConfig{
+				LocalServicePort: 80,
+				Upstreams: []consul.Upstream{
+					{DestinationName: "db", LocalBindPort: 5432},
+					{DestinationName: "cache", LocalBindPort: 6379, Datacenter: "dc2"},
+				},
+			},
+		},
+	}, connect)
+}
+
+func TestBuildWeights(t *testing.T) {
+	assert.Nil(t, buildWeights(newTestService(map[string]string{})))
+	assert.Equal(t, &consul.AgentWeights{Passing: 10, Warning: 1},
+		buildWeights(newTestService(map[string]string{"weight_passing": "10"})))
+}
+
+func TestBuildTaggedAddresses(t *testing.T) {
+	service := newTestService(map[string]string{
+		"tagged_address_lan": "container",
+		"tagged_address_wan": "host",
+	})
+	service.Origin.HostIP = "192.168.1.10"
+	service.Origin.HostPort = "8080"
+	service.Origin.ExposedIP = "172.17.0.2"
+	assert.Equal(t, map[string]consul.ServiceAddress{
+		"lan": {Address: "172.17.0.2", Port: 80},
+		"wan": {Address: "192.168.1.10", Port: 8080},
+	}, buildTaggedAddresses(service))
+
+	explicit := newTestService(map[string]string{"tagged_address_wan": "203.0.113.7"})
+	assert.Equal(t, map[string]consul.ServiceAddress{
+		"wan": {Address: "203.0.113.7", Port: 8080},
+	}, buildTaggedAddresses(explicit))
+}
+
+func TestTTLCheckIDs(t *testing.T) {
+	service := newTestService(map[string]string{
+		"check_ttl":   "30s",
+		"check_1_tcp": "true",
+		"check_2_ttl": "1m",
+	})
+	assert.Equal(t, []string{"service:host:web:80", "service:host:web:80:ttl-2"}, ttlCheckIDs(service))
+	assert.Equal(t, "service:host:web:80", (&Consul{}).buildCheck(service, "check").CheckID)
+}
+
+func TestHealthStatus(t *testing.T) {
+	status, _ := healthStatus(nil)
+	assert.Equal(t, consul.HealthPassing, status)
+
+	status, output := healthStatus(&types.Health{
+		Status: types.Unhealthy,
+		Log:    []*types.HealthcheckResult{{Output: "connection refused"}},
+	})
+	assert.Equal(t, consul.HealthCritical, status)
+	assert.Equal(t, "docker health: unhealthy\nconnection refused", output)
+
+	status, _ = healthStatus(&types.Health{Status: types.Starting})
+	assert.Equal(t, consul.HealthWarning, status)
+}
+
+func TestBuildCheckDockerHealth(t *testing.T) {
+	service := newTestService(map[string]string{"check_docker_health": "true"})
+	check := (&Consul{}).buildCheck(service, "check")
+	assert.Equal(t, &consul.AgentServiceCheck{
+		CheckID: "service:host:web:80",
+		TTL:     DefaultDockerHealthTTL,
+		Status:  consul.HealthPassing,
+	}, check)
+	assert.Equal(t, []string{"service:host:web:80"}, ttlCheckIDs(service))
+
+	for _, disabled := range []string{"false", "0"} {
+		service := newTestService(map[string]string{"check_docker_health": disabled})
+		assert.Nil(t, (&Consul{}).buildCheck(service, "check"), disabled)
+		assert.Empty(t, ttlCheckIDs(service), disabled)
+	}
+}
+
+func TestNeedsHealth(t *testing.T) {
+	tests := []struct {
+		attrs    map[string]string
+		expected bool
+	}{
+		{attrs: map[string]string{}, expected: false},
+		{attrs: map[string]string{"check_http": "/health"}, expected: false},
+		{attrs: map[string]string{"check_docker_health": "false"}, expected: false},
+		{attrs: map[string]string{"check_docker_health": "true"}, expected: true},
+		{attrs: map[string]string{"check_tcp": "true", "check_1_ttl": "30s"}, expected: true},
+	}
+
+	r := &Consul{}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, r.NeedsHealth(newTestService(tt.attrs)), tt.attrs)
+	}
+}
+
+func TestServiceNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		attrs     map[string]string
+		expected  string
+	}{
+		{namespace: "", attrs: map[string]string{}, expected: ""},
+		{namespace: "team-a", attrs: map[string]string{}, expected: "team-a"},
+		{namespace: "team-a", attrs: map[string]string{"namespace": "team-b"}, expected: "team-b"},
+		{namespace: "", attrs: map[string]string{"namespace": "team-b"}, expected: "team-b"},
+	}
+
+	for _, tt := range tests {
+		r := &Consul{namespace: tt.namespace}
+		assert.Equal(t, tt.expected, r.serviceNamespace(newTestService(tt.attrs)))
+	}
+}
+
+func TestListNamespaces(t *testing.T) {
+	tests := []struct {
+		enterprise bool
+		expected   []string
+	}{
+		{enterprise: false, expected: []string{"team-a", "team-b"}},
+		{enterprise: true, expected: []string{"default", "team-a", "team-b", "team-c"}},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !tt.enterprise || req.URL.Path != "/v1/namespaces" {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write([]byte(`[{"Name":"default"},{"Name":"team-a"},{"Name":"team-c"}]`))
+		}))
+		config := consul.DefaultConfig()
+		config.Address = server.Listener.Addr().String()
+		client, err := consul.NewClient(config)
+		assert.NoError(t, err)
+		r := &Consul{client: client, namespace: "team-a", namespaces: map[string]bool{"team-a": true, "team-b": true}}
+
+		assert.Equal(t, tt.expected, r.listNamespaces())
+		server.Close()
+	}
+}
+
+func TestBuildMetaOmitsChecks(t *testing.T) {
+	r := &Consul{}
+	service := newTestService(map[string]string{
+		"region":               "eu",
+		"check_http":           "/health",
+		"check_http_headers":   "Authorization: Bearer s3cr3t",
+		"check_http_body":      `{"token":"s3cr3t"}`,
+		"check_1_http_headers": "X-Token: s3cr3t",
+		"checksum":             "abc",
+	})
+	service.Origin.ContainerName = "web-1"
+
+	assert.Equal(t, map[string]string{
+		"region":                  "eu",
+		"checksum":                "abc",
+		bridge.OwnerHostAttr:      bridge.Hostname,
+		bridge.OwnerContainerAttr: "web-1",
+	}, r.buildMeta(service))
+}
+
+func TestEnableTagOverride(t *testing.T) {
+	tests := map[string]bool{
+		"":      false,
+		"true":  true,
+		"1":     true,
+		"false": false,
+		"0":     false,
+		"yes":   false,
+	}
+
+	for value, expected := range tests {
+		service := newTestService(map[string]string{"enable_tag_override": value})
+		assert.Equal(t, expected, enableTagOverride(service), value)
+	}
+}
+
+var _ bridge.HealthAware = (*Consul)(nil)
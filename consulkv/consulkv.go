@@ -1,22 +1,41 @@
 package consul
 
 import (
+	"encoding/json"
+	"errors"
 	log "log/slog"
 	"net"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-cleanhttp"
 	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/payload"
 )
 
 func init() {
 	f := new(Factory)
 	bridge.Register(f, "consulkv")
 	bridge.Register(f, "consulkv-unix")
+	bridge.Register(f, "consulkv-tls")
 }
 
+// Session TTL bounds enforced by Consul.
+const (
+	minSessionTTL = 10
+	maxSessionTTL = 86400
+)
+
+// sessionLockDelay replaces Consul's default 15s lock delay, which would
+// keep a restarted registrator from re-acquiring its keys once the previous
+// run's session expires. Consul applies its default when it is zero.
+const sessionLockDelay = time.Millisecond
+
 type Factory struct{}
 
 func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
@@ -25,6 +44,23 @@ func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
 	if uri.Scheme == "consulkv-unix" {
 		spl := strings.SplitN(uri.Path, ":", 2)
 		config.Address, path = "unix://"+spl[0], spl[1]
+	} else if uri.Scheme == "consulkv-tls" {
+		tlsConfigDesc := &consul.TLSConfig{
+			Address:            uri.Host,
+			CAFile:             os.Getenv("CONSUL_CACERT"),
+			CertFile:           os.Getenv("CONSUL_CLIENT_CERT"),
+			KeyFile:            os.Getenv("CONSUL_CLIENT_KEY"),
+			InsecureSkipVerify: false,
+		}
+		tlsConfig, err := consul.SetupTLSConfig(tlsConfigDesc)
+		if err != nil {
+			log.Error("Cannot set up Consul TLSConfig", "error", err)
+		}
+		config.Scheme = "https"
+		transport := cleanhttp.DefaultPooledTransport()
+		transport.TLSClientConfig = tlsConfig
+		config.Transport = transport
+		config.Address = uri.Host
 	} else if uri.Host != "" {
 		config.Address = uri.Host
 	}
@@ -32,12 +68,21 @@ func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
 	if err != nil {
 		log.Error("consulkv: ", "error", err)
 	}
-	return &ConsulKV{client: client, path: path}
+	return &ConsulKV{
+		client:   client,
+		path:     path,
+		json:     uri.Query().Get("format") == "json",
+		sessions: make(map[string]string),
+	}
 }
 
 type ConsulKV struct {
 	client *consul.Client
 	path   string
+	json   bool
+
+	sync.Mutex
+	sessions map[string]string
 }
 
 // Ping will try to connect to consul by attempting to retrieve the current leader.
@@ -55,14 +100,68 @@ func (r *ConsulKV) Ping() error {
 func (r *ConsulKV) Register(service *bridge.Service) error {
 	log.Info("Register")
 	path := r.path[1:] + "/" + service.Name + "/" + service.ID
-	port := strconv.Itoa(service.Port)
-	addr := net.JoinHostPort(service.IP, port)
 	log.Info("path", "path", path)
-	_, err := r.client.KV().Put(&consul.KVPair{Key: path, Value: []byte(addr)}, nil)
+	value, err := r.encode(service)
+	if err != nil {
+		log.Error("consulkv: failed to json encode service", "error", err)
+		return err
+	}
+	pair := &consul.KVPair{Key: path, Value: value}
+	if service.TTL <= 0 {
+		_, err := r.client.KV().Put(pair, nil)
+		if err != nil {
+			log.Error("consulkv: failed to register service", "error", err)
+		}
+		return err
+	}
+
+	session, err := r.session(service)
+	if err != nil {
+		log.Error("consulkv: failed to create session", "error", err)
+		return err
+	}
+	pair.Session = session
+	acquired, _, err := r.client.KV().Acquire(pair, nil)
 	if err != nil {
 		log.Error("consulkv: failed to register service", "error", err)
+		return err
 	}
-	return err
+	if !acquired {
+		log.Error("consulkv: key is held by another session", "key", path)
+		return errors.New("consulkv: key " + path + " is held by another session")
+	}
+	return nil
+}
+
+// session returns the session the service's key is bound to, creating one
+// with the service TTL if needed. Keys are deleted when it expires.
+func (r *ConsulKV) session(service *bridge.Service) (string, error) {
+	r.Lock()
+	defer r.Unlock()
+	if id, ok := r.sessions[service.ID]; ok {
+		return id, nil
+	}
+	id, _, err := r.client.Session().Create(&consul.SessionEntry{
+		Name:      "registrator:" + service.ID,
+		TTL:       sessionTTL(service),
+		Behavior:  consul.SessionBehaviorDelete,
+		LockDelay: sessionLockDelay,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	r.sessions[service.ID] = id
+	return id, nil
+}
+
+// sessionTTL clamps the service TTL to the range Consul accepts for sessions.
+func sessionTTL(service *bridge.Service) string {
+	ttl := service.TTL
+	if ttl < minSessionTTL || ttl > maxSessionTTL {
+		ttl = min(max(ttl, minSessionTTL), maxSessionTTL)
+		log.Info("consulkv: session TTL out of range, clamping", "serviceID", service.ID, "ttl", service.TTL, "clamped", ttl)
+	}
+	return strconv.Itoa(ttl) + "s"
 }
 
 func (r *ConsulKV) Deregister(service *bridge.Service) error {
@@ -71,13 +170,89 @@ func (r *ConsulKV) Deregister(service *bridge.Service) error {
 	if err != nil {
 		log.Error("consulkv: failed to deregister service", "error", err)
 	}
+	r.Lock()
+	session, ok := r.sessions[service.ID]
+	delete(r.sessions, service.ID)
+	r.Unlock()
+	if ok {
+		if _, err := r.client.Session().Destroy(session, nil); err != nil {
+			log.Error("consulkv: failed to destroy session", "error", err)
+		}
+	}
 	return err
 }
 
-func (r *ConsulKV) Refresh(_ *bridge.Service) error {
-	return nil
+func (r *ConsulKV) Refresh(service *bridge.Service) error {
+	if service.TTL <= 0 {
+		return nil
+	}
+	r.Lock()
+	session, ok := r.sessions[service.ID]
+	r.Unlock()
+	if ok {
+		entry, _, err := r.client.Session().Renew(session, nil)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			log.Info("consulkv: session expired, re-registering", "serviceID", service.ID)
+			r.Lock()
+			delete(r.sessions, service.ID)
+			r.Unlock()
+		}
+	}
+	return r.Register(service)
 }
 
 func (r *ConsulKV) Services() ([]*bridge.Service, error) {
-	return []*bridge.Service{}, nil
+	prefix := r.path[1:] + "/"
+	pairs, _, err := r.client.KV().List(prefix, nil)
+	if err != nil {
+		return []*bridge.Service{}, err
+	}
+	services := make([]*bridge.Service, 0, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.Split(strings.TrimPrefix(pair.Key, prefix), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		service, err := decode(parts[0], parts[1], pair.Value)
+		if err != nil {
+			log.Error("consulkv: failed to decode service", "key", pair.Key, "error", err)
+			continue
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func (r *ConsulKV) encode(service *bridge.Service) ([]byte, error) {
+	if !r.json {
+		return []byte(net.JoinHostPort(service.IP, strconv.Itoa(service.Port))), nil
+	}
+	return json.Marshal(payload.New(service))
+}
+
+// decode accepts both the plain <ip>:<port> and the JSON value, so cleanup
+// works across a change of format.
+func decode(name, id string, data []byte) (*bridge.Service, error) {
+	service := &bridge.Service{ID: id, Name: name}
+	if strings.HasPrefix(string(data), "{") {
+		v := new(payload.Value)
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, err
+		}
+		service.IP, service.Port, service.Tags, service.Attrs = v.IP, v.Port, v.Tags, v.Attrs
+		return service, nil
+	}
+	host, port, err := net.SplitHostPort(string(data))
+	if err != nil {
+		return nil, err
+	}
+	service.IP = host
+	service.Port, err = strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
 }
@@ -0,0 +1,285 @@
+package consul
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsul serves the session and KV endpoints used by the adapter.
+type fakeConsul struct {
+	sync.Mutex
+	sessions map[string]*consul.SessionEntry
+	kv       map[string]*consul.KVPair
+	renewals int
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	path := req.URL.Path
+	switch {
+	case path == "/v1/session/create":
+		// the client sends LockDelay as a duration string
+		var body struct {
+			consul.SessionEntry
+			LockDelay string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entry := &body.SessionEntry
+		if body.LockDelay != "" {
+			lockDelay, err := time.ParseDuration(body.LockDelay)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			entry.LockDelay = lockDelay
+		}
+		entry.ID = "session-" + entry.Name
+		f.sessions[entry.ID] = entry
+		json.NewEncoder(w).Encode(map[string]string{"ID": entry.ID})
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		f.renewals++
+		entry, ok := f.sessions[strings.TrimPrefix(path, "/v1/session/renew/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		json.NewEncoder(w).Encode([]*consul.SessionEntry{entry})
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		delete(f.sessions, strings.TrimPrefix(path, "/v1/session/destroy/"))
+		w.Write([]byte("true"))
+	case strings.HasPrefix(path, "/v1/kv/"):
+		f.serveKV(w, req, strings.TrimPrefix(path, "/v1/kv/"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeConsul) serveKV(w http.ResponseWriter, req *http.Request, key string) {
+	switch req.Method {
+	case http.MethodPut:
+		pair := &consul.KVPair{Key: key, Session: req.URL.Query().Get("acquire")}
+		if held, ok := f.kv[key]; ok && held.Session != "" && held.Session != pair.Session {
+			w.Write([]byte("false"))
+			return
+		}
+		value, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pair.Value = value
+		f.kv[key] = pair
+		w.Write([]byte("true"))
+	case http.MethodDelete:
+		delete(f.kv, key)
+		w.Write([]byte("true"))
+	default:
+		pairs := make([]*consul.KVPair, 0)
+		for k, pair := range f.kv {
+			if strings.HasPrefix(k, key) {
+				pairs = append(pairs, pair)
+			}
+		}
+		json.NewEncoder(w).Encode(pairs)
+	}
+}
+
+func newTestConsulKV(t *testing.T, format string) (*ConsulKV, *fakeConsul) {
+	fake := &fakeConsul{
+		sessions: make(map[string]*consul.SessionEntry),
+		kv:       make(map[string]*consul.KVPair),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	config := consul.DefaultConfig()
+	config.Address = server.Listener.Addr().String()
+	client, err := consul.NewClient(config)
+	assert.NoError(t, err)
+	return &ConsulKV{
+		client:   client,
+		path:     "/services",
+		json:     format == "json",
+		sessions: make(map[string]string),
+	}, fake
+}
+
+func TestSessionTTL(t *testing.T) {
+	tests := []struct {
+		ttl      int
+		expected string
+	}{
+		{ttl: 1, expected: "10s"},
+		{ttl: 10, expected: "10s"},
+		{ttl: 30, expected: "30s"},
+		{ttl: 86400, expected: "86400s"},
+		{ttl: 100000, expected: "86400s"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, sessionTTL(&bridge.Service{ID: "web", TTL: tt.ttl}), tt.ttl)
+	}
+}
+
+func TestRegisterCreatesSession(t *testing.T) {
+	r, fake := newTestConsulKV(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 5}
+
+	assert.NoError(t, r.Register(service))
+	assert.Len(t, fake.sessions, 1)
+	session := r.sessions[service.ID]
+	entry := fake.sessions[session]
+	assert.Equal(t, "registrator:host:web:80", entry.Name)
+	assert.Equal(t, "10s", entry.TTL)
+	assert.Equal(t, consul.SessionBehaviorDelete, entry.Behavior)
+	assert.Equal(t, sessionLockDelay, entry.LockDelay)
+	assert.Equal(t, session, fake.kv["services/web/host:web:80"].Session)
+	assert.Equal(t, "10.0.0.5:8080", string(fake.kv["services/web/host:web:80"].Value))
+
+	// registering again reuses the session
+	assert.NoError(t, r.Register(service))
+	assert.Len(t, fake.sessions, 1)
+
+	assert.NoError(t, r.Deregister(service))
+	assert.Empty(t, fake.sessions)
+	assert.Empty(t, fake.kv)
+	assert.Empty(t, r.sessions)
+}
+
+func TestRegisterWithoutTTL(t *testing.T) {
+	r, fake := newTestConsulKV(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080}
+
+	assert.NoError(t, r.Register(service))
+	assert.Empty(t, fake.sessions)
+	assert.Empty(t, fake.kv["services/web/host:web:80"].Session)
+	assert.NoError(t, r.Refresh(service))
+	assert.Zero(t, fake.renewals)
+}
+
+func TestRefreshRenewsSession(t *testing.T) {
+	r, fake := newTestConsulKV(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30}
+
+	assert.NoError(t, r.Register(service))
+	session := r.sessions[service.ID]
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, 1, fake.renewals)
+	assert.Equal(t, session, r.sessions[service.ID])
+	assert.Len(t, fake.sessions, 1)
+}
+
+func TestRefreshExpiredSession(t *testing.T) {
+	r, fake := newTestConsulKV(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30}
+
+	assert.NoError(t, r.Register(service))
+	// Consul deletes the keys of an expired session
+	fake.sessions = make(map[string]*consul.SessionEntry)
+	fake.kv = make(map[string]*consul.KVPair)
+
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, 1, fake.renewals)
+	assert.Len(t, fake.sessions, 1)
+	assert.Equal(t, r.sessions[service.ID], fake.kv["services/web/host:web:80"].Session)
+}
+
+func TestRegisterKeyHeldByAnotherSession(t *testing.T) {
+	r, fake := newTestConsulKV(t, "")
+	fake.kv["services/web/host:web:80"] = &consul.KVPair{Key: "services/web/host:web:80", Session: "other"}
+
+	err := r.Register(&bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30})
+	assert.ErrorContains(t, err, "held by another session")
+}
+
+func TestRefreshAfterRestart(t *testing.T) {
+	r, fake := newTestConsulKV(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30}
+	// the previous run's session still holds the key
+	fake.kv["services/web/host:web:80"] = &consul.KVPair{Key: "services/web/host:web:80", Session: "previous"}
+
+	assert.ErrorContains(t, r.Register(service), "held by another session")
+
+	// once it expires, Consul deletes the key and the next refresh takes it
+	delete(fake.kv, "services/web/host:web:80")
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, r.sessions[service.ID], fake.kv["services/web/host:web:80"].Session)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	service := &bridge.Service{
+		ID:    "host:web:80",
+		Name:  "web",
+		IP:    "10.0.0.5",
+		Port:  8080,
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]string{"region": "eu"},
+	}
+	tests := []struct {
+		format   string
+		encoded  string
+		expected *bridge.Service
+	}{
+		{
+			format:   "",
+			encoded:  "10.0.0.5:8080",
+			expected: &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080},
+		},
+		{
+			format:   "json",
+			encoded:  `{"id":"host:web:80","name":"web","ip":"10.0.0.5","port":8080,"tags":["a","b"],"attrs":{"region":"eu"}}`,
+			expected: service,
+		},
+	}
+
+	for _, tt := range tests {
+		r := &ConsulKV{json: tt.format == "json"}
+		data, err := r.encode(service)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.encoded, string(data))
+		decoded, err := decode("web", "host:web:80", data)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, decoded)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, data := range []string{"", "10.0.0.5", "10.0.0.5:http", "{not json"} {
+		_, err := decode("web", "host:web:80", []byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestServices(t *testing.T) {
+	tests := []string{"", "json"}
+
+	for _, format := range tests {
+		r, fake := newTestConsulKV(t, format)
+		service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Tags: []string{"a"}}
+		assert.NoError(t, r.Register(service))
+		fake.kv["services/web/broken"] = &consul.KVPair{Key: "services/web/broken", Value: []byte("garbage")}
+		fake.kv["services/web"] = &consul.KVPair{Key: "services/web"}
+
+		services, err := r.Services()
+		assert.NoError(t, err)
+		if assert.Len(t, services, 1, format) {
+			assert.Equal(t, "host:web:80", services[0].ID)
+			assert.Equal(t, "web", services[0].Name)
+			assert.Equal(t, "10.0.0.5", services[0].IP)
+			assert.Equal(t, 8080, services[0].Port)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package coredns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/skydns2"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const defaultEndpoint = "127.0.0.1:2379"
+
+const requestTimeout = 5 * time.Second
+
+func init() {
+	bridge.Register(new(Factory), "coredns")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	endpoints := []string{defaultEndpoint}
+	if uri.Host != "" {
+		endpoints = strings.Split(uri.Host, ",")
+	}
+
+	if len(uri.Path) < 2 {
+		log.Error("coredns: dns domain required e.g.: coredns://<host>/<domain>")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: requestTimeout,
+	})
+	if err != nil {
+		log.Error("coredns: failed to create etcd client", "error", err)
+	}
+
+	return &CoreDNS{
+		client: client,
+		err:    err,
+		path:   skydns2.DomainPath(strings.TrimPrefix(uri.Path, "/")),
+		leases: make(map[string]clientv3.LeaseID),
+	}
+}
+
+type CoreDNS struct {
+	client *clientv3.Client
+	// err is the error creating the client, returned by every call when
+	// there is no client.
+	err  error
+	path string
+
+	sync.Mutex
+	leases map[string]clientv3.LeaseID
+}
+
+// record mirrors the msg.Service JSON understood by the CoreDNS etcd plugin.
+type record struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	Text     string `json:"text,omitempty"`
+	TTL      uint32 `json:"ttl,omitempty"`
+}
+
+func (r *CoreDNS) Ping() error {
+	if r.client == nil {
+		return r.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := r.client.Status(ctx, r.client.Endpoints()[0])
+	return err
+}
+
+func (r *CoreDNS) Register(service *bridge.Service) error {
+	if r.client == nil {
+		return r.err
+	}
+	value, err := json.Marshal(newRecord(service))
+	if err != nil {
+		log.Error("coredns: failed to json encode service record", "error", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	lease := clientv3.NoLease
+	if service.TTL > 0 {
+		grant, err := r.client.Grant(ctx, int64(service.TTL))
+		if err != nil {
+			log.Error("coredns: failed to grant lease", "error", err)
+			return err
+		}
+		lease = grant.ID
+	}
+
+	_, err = r.client.Put(ctx, r.servicePath(service), string(value), clientv3.WithLease(lease))
+	if err != nil {
+		log.Error("coredns: failed to register service", "error", err)
+		return err
+	}
+	r.revoke(ctx, service.ID, r.swapLease(service.ID, lease))
+	return nil
+}
+
+func (r *CoreDNS) Deregister(service *bridge.Service) error {
+	if r.client == nil {
+		return r.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	_, err := r.client.Delete(ctx, r.servicePath(service))
+	if err != nil {
+		log.Error("coredns: failed to deregister service", "error", err)
+	}
+	r.revoke(ctx, service.ID, r.swapLease(service.ID, clientv3.NoLease))
+	return err
+}
+
+func (r *CoreDNS) Refresh(service *bridge.Service) error {
+	if r.client == nil {
+		return r.err
+	}
+	r.Lock()
+	lease, ok := r.leases[service.ID]
+	r.Unlock()
+	if !ok {
+		return r.Register(service)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if _, err := r.client.KeepAliveOnce(ctx, lease); err != nil {
+		log.Info("coredns: lease keepalive failed, re-registering", "serviceID", service.ID, "error", err)
+		return r.Register(service)
+	}
+	return nil
+}
+
+// Services lists the records under the domain. Keys are
+// <domain path>/<name>/[_<proto>/_<port name>/]<id>.
+func (r *CoreDNS) Services() ([]*bridge.Service, error) {
+	if r.client == nil {
+		return []*bridge.Service{}, r.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	resp, err := r.client.Get(ctx, r.path+"/", clientv3.WithPrefix())
+	if err != nil {
+		return []*bridge.Service{}, err
+	}
+	services := make([]*bridge.Service, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		service, err := r.decodeService(string(kv.Key), kv.Value)
+		if err != nil {
+			log.Debug("coredns: skipping record", "key", string(kv.Key), "error", err)
+			continue
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func (r *CoreDNS) decodeService(key string, value []byte) (*bridge.Service, error) {
+	parts := strings.Split(strings.TrimPrefix(key, r.path+"/"), "/")
+	if len(parts) != 2 && (len(parts) != 4 || !strings.HasPrefix(parts[1], "_") || !strings.HasPrefix(parts[2], "_")) {
+		return nil, errors.New("not a service record")
+	}
+	rec := new(record)
+	if err := json.Unmarshal(value, rec); err != nil {
+		return nil, err
+	}
+	service := &bridge.Service{
+		ID:    parts[len(parts)-1],
+		Name:  parts[0],
+		IP:    rec.Host,
+		Port:  rec.Port,
+		Attrs: make(map[string]string),
+	}
+	if len(parts) == 4 {
+		service.Origin.ExposedPortProtocol = strings.TrimPrefix(parts[1], "_")
+		service.Attrs["port_name"] = strings.TrimPrefix(parts[2], "_")
+	}
+	if rec.Priority != 0 {
+		service.Attrs["priority"] = strconv.Itoa(rec.Priority)
+	}
+	if rec.Weight != 0 {
+		service.Attrs["weight"] = strconv.Itoa(rec.Weight)
+	}
+	if rec.Text != "" {
+		service.Attrs["text"] = rec.Text
+	}
+	if rec.TTL != 0 {
+		service.Attrs["ttl"] = strconv.FormatUint(uint64(rec.TTL), 10)
+	}
+	return service, nil
+}
+
+// swapLease remembers the lease bound to a service and returns the one it
+// replaces, which should be revoked, or NoLease.
+func (r *CoreDNS) swapLease(serviceID string, lease clientv3.LeaseID) clientv3.LeaseID {
+	r.Lock()
+	defer r.Unlock()
+	previous, ok := r.leases[serviceID]
+	if lease == clientv3.NoLease {
+		delete(r.leases, serviceID)
+	} else {
+		r.leases[serviceID] = lease
+	}
+	if !ok || previous == lease {
+		return clientv3.NoLease
+	}
+	return previous
+}
+
+func (r *CoreDNS) revoke(ctx context.Context, serviceID string, lease clientv3.LeaseID) {
+	if lease == clientv3.NoLease {
+		return
+	}
+	if _, err := r.client.Revoke(ctx, lease); err != nil {
+		log.Debug("coredns: failed to revoke lease", "serviceID", serviceID, "error", err)
+	}
+}
+
+// servicePath places each port under _<proto>/_<port name> so that SRV queries
+// such as _http._tcp.<name>.<domain> resolve, while plain <name>.<domain>
+// lookups still match through the prefix.
+func (r *CoreDNS) servicePath(service *bridge.Service) string {
+	proto := service.Origin.ExposedPortProtocol
+	if proto == "" {
+		proto = "tcp"
+	}
+	portName := service.Attrs["port_name"]
+	if portName == "" {
+		portName = service.Origin.ExposedPort
+	}
+	if portName == "" {
+		return r.path + "/" + service.Name + "/" + service.ID
+	}
+	return r.path + "/" + service.Name + "/_" + proto + "/_" + portName + "/" + service.ID
+}
+
+func newRecord(service *bridge.Service) *record {
+	rec := &record{
+		Host: service.IP,
+		Port: service.Port,
+		Text: service.Attrs["text"],
+	}
+	if priority, err := strconv.Atoi(service.Attrs["priority"]); err == nil {
+		rec.Priority = priority
+	}
+	if weight, err := strconv.Atoi(service.Attrs["weight"]); err == nil {
+		rec.Weight = weight
+	}
+	if ttl, err := strconv.ParseUint(service.Attrs["ttl"], 10, 32); err == nil {
+		rec.TTL = uint32(ttl)
+	}
+	return rec
+}
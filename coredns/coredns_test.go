@@ -0,0 +1,112 @@
+package coredns
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestServicePath(t *testing.T) {
+	r := &CoreDNS{path: "/skydns/local/cluster"}
+	for _, tc := range []struct {
+		name    string
+		service *bridge.Service
+		path    string
+	}{
+		{"no port", &bridge.Service{ID: "host:web", Name: "web"},
+			"/skydns/local/cluster/web/host:web"},
+		{"exposed port", &bridge.Service{ID: "host:web:80", Name: "web", Origin: bridge.ServicePort{ExposedPort: "80"}},
+			"/skydns/local/cluster/web/_tcp/_80/host:web:80"},
+		{"port name", &bridge.Service{ID: "host:dns:53:udp", Name: "dns", Attrs: map[string]string{"port_name": "dns"},
+			Origin: bridge.ServicePort{ExposedPort: "53", ExposedPortProtocol: "udp"}},
+			"/skydns/local/cluster/dns/_udp/_dns/host:dns:53:udp"},
+	} {
+		assert.Equal(t, tc.path, r.servicePath(tc.service), tc.name)
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		attrs map[string]string
+		json  string
+	}{
+		{"plain", map[string]string{}, `{"host":"10.0.0.5","port":8080}`},
+		{"all attrs", map[string]string{"priority": "10", "weight": "50", "text": "hi", "ttl": "30"},
+			`{"host":"10.0.0.5","port":8080,"priority":10,"weight":50,"text":"hi","ttl":30}`},
+		{"invalid attrs", map[string]string{"priority": "high", "ttl": "-1"}, `{"host":"10.0.0.5","port":8080}`},
+	} {
+		value, err := json.Marshal(newRecord(&bridge.Service{IP: "10.0.0.5", Port: 8080, Attrs: tc.attrs}))
+		assert.NoError(t, err)
+		assert.JSONEq(t, tc.json, string(value), tc.name)
+	}
+}
+
+func TestDecodeService(t *testing.T) {
+	r := &CoreDNS{path: "/skydns/local/cluster"}
+	for _, tc := range []struct {
+		name    string
+		key     string
+		value   string
+		service *bridge.Service
+	}{
+		{"srv layout", "/skydns/local/cluster/web/_tcp/_http/host:web:80", `{"host":"10.0.0.5","port":8080,"weight":5}`,
+			&bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080,
+				Attrs:  map[string]string{"port_name": "http", "weight": "5"},
+				Origin: bridge.ServicePort{ExposedPortProtocol: "tcp"}}},
+		{"flat layout", "/skydns/local/cluster/web/host:web", `{"host":"10.0.0.5"}`,
+			&bridge.Service{ID: "host:web", Name: "web", IP: "10.0.0.5", Attrs: map[string]string{}}},
+		{"other record", "/skydns/local/cluster/a/b/c", `{"host":"10.0.0.5"}`, nil},
+		{"malformed", "/skydns/local/cluster/web/host:web", `not json`, nil},
+	} {
+		service, err := r.decodeService(tc.key, []byte(tc.value))
+		if tc.service == nil {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.service, service, tc.name)
+	}
+
+	// the path written by servicePath decodes back to the same service
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080,
+		Attrs: map[string]string{"port_name": "http"}, Origin: bridge.ServicePort{ExposedPortProtocol: "tcp"}}
+	value, _ := json.Marshal(newRecord(service))
+	decoded, err := r.decodeService(r.servicePath(service), value)
+	assert.NoError(t, err)
+	assert.Equal(t, service, decoded)
+}
+
+func TestSwapLease(t *testing.T) {
+	r := &CoreDNS{leases: make(map[string]clientv3.LeaseID)}
+	for _, tc := range []struct {
+		name    string
+		lease   clientv3.LeaseID
+		revoked clientv3.LeaseID
+	}{
+		{"first lease", 1, clientv3.NoLease},
+		{"same lease", 1, clientv3.NoLease},
+		{"new lease", 2, 1},
+		{"deregistered", clientv3.NoLease, 2},
+		{"deregistered again", clientv3.NoLease, clientv3.NoLease},
+	} {
+		assert.Equal(t, tc.revoked, r.swapLease("host:web:80", tc.lease), tc.name)
+	}
+	assert.Empty(t, r.leases)
+}
+
+func TestNoClient(t *testing.T) {
+	err := errors.New("bad config")
+	r := &CoreDNS{err: err, leases: make(map[string]clientv3.LeaseID)}
+	service := &bridge.Service{ID: "host:web:80", Name: "web"}
+	assert.Equal(t, err, r.Ping())
+	assert.Equal(t, err, r.Register(service))
+	assert.Equal(t, err, r.Refresh(service))
+	assert.Equal(t, err, r.Deregister(service))
+	_, servicesErr := r.Services()
+	assert.Equal(t, err, servicesErr)
+}
@@ -0,0 +1,286 @@
+package eureka
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+const (
+	// defaultLeaseDuration is how long Eureka keeps an instance without
+	// heartbeats when the service has no TTL, the Eureka default.
+	defaultLeaseDuration = 90
+	dataCenterClass      = "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo"
+)
+
+var errNotFound = errors.New("eureka: instance not found")
+
+func init() {
+	f := new(Factory)
+	bridge.Register(f, "eureka")
+	bridge.Register(f, "eurekas")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	base := &url.URL{Scheme: "http", Host: uri.Host, Path: strings.TrimSuffix(uri.Path, "/")}
+	if uri.Scheme == "eurekas" {
+		base.Scheme = "https"
+	}
+	if base.Path == "" {
+		base.Path = "/eureka"
+	}
+	return &Eureka{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		base:       base.String(),
+		user:       uri.User,
+		datacenter: uri.Query().Get("datacenter"),
+	}
+}
+
+// Eureka registers services as instances of the Eureka app of the same name
+// through the Eureka REST API.
+type Eureka struct {
+	client     *http.Client
+	base       string
+	user       *url.Userinfo
+	datacenter string
+}
+
+// port is how Eureka serializes ports in JSON.
+type port struct {
+	Port    int    `json:"$"`
+	Enabled string `json:"@enabled"`
+}
+
+type dataCenterInfo struct {
+	Class string `json:"@class"`
+	Name  string `json:"name"`
+}
+
+type leaseInfo struct {
+	RenewalIntervalInSecs int `json:"renewalIntervalInSecs"`
+	DurationInSecs        int `json:"durationInSecs"`
+}
+
+type instance struct {
+	InstanceID       string            `json:"instanceId"`
+	HostName         string            `json:"hostName"`
+	App              string            `json:"app"`
+	IPAddr           string            `json:"ipAddr"`
+	VipAddress       string            `json:"vipAddress"`
+	SecureVipAddress string            `json:"secureVipAddress"`
+	Status           string            `json:"status"`
+	Port             port              `json:"port"`
+	SecurePort       port              `json:"securePort"`
+	HealthCheckURL   string            `json:"healthCheckUrl,omitempty"`
+	StatusPageURL    string            `json:"statusPageUrl,omitempty"`
+	HomePageURL      string            `json:"homePageUrl,omitempty"`
+	DataCenterInfo   dataCenterInfo    `json:"dataCenterInfo"`
+	LeaseInfo        leaseInfo         `json:"leaseInfo"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type instanceBody struct {
+	Instance *instance `json:"instance"`
+}
+
+type applicationsBody struct {
+	Applications struct {
+		Application []struct {
+			Name     string      `json:"name"`
+			Instance []*instance `json:"instance"`
+		} `json:"application"`
+	} `json:"applications"`
+}
+
+func (r *Eureka) Ping() error {
+	return r.do(http.MethodGet, "/apps", nil, nil)
+}
+
+func (r *Eureka) Register(service *bridge.Service) error {
+	body := &instanceBody{Instance: r.buildInstance(service)}
+	err := r.do(http.MethodPost, "/apps/"+appName(service.Name), body, nil)
+	if err != nil {
+		log.Error("eureka: failed to register service", "serviceID", service.ID, "error", err)
+	}
+	return err
+}
+
+func (r *Eureka) Deregister(service *bridge.Service) error {
+	err := r.do(http.MethodDelete, instancePath(service), nil, nil)
+	if err == errNotFound {
+		return nil
+	}
+	return err
+}
+
+// Refresh sends a heartbeat and registers the instance again when Eureka
+// has evicted it.
+func (r *Eureka) Refresh(service *bridge.Service) error {
+	err := r.do(http.MethodPut, instancePath(service), nil, nil)
+	if err == errNotFound {
+		log.Info("eureka: instance not found, re-registering", "serviceID", service.ID)
+		return r.Register(service)
+	}
+	return err
+}
+
+// Services lists the instances registered by registrator, those with the
+// ownership metadata. Others, e.g. Spring apps whose default instance ID
+// looks like a registrator service ID, are never returned for cleanup.
+func (r *Eureka) Services() ([]*bridge.Service, error) {
+	apps := new(applicationsBody)
+	if err := r.do(http.MethodGet, "/apps", nil, apps); err != nil {
+		return []*bridge.Service{}, err
+	}
+	services := make([]*bridge.Service, 0)
+	for _, app := range apps.Applications.Application {
+		for _, i := range app.Instance {
+			if i.Metadata[bridge.OwnerHostAttr] == "" {
+				continue
+			}
+			services = append(services, decodeInstance(i))
+		}
+	}
+	return services, nil
+}
+
+func appName(name string) string {
+	return strings.ToUpper(name)
+}
+
+func instancePath(service *bridge.Service) string {
+	return "/apps/" + appName(service.Name) + "/" + url.PathEscape(service.ID)
+}
+
+func (r *Eureka) buildInstance(service *bridge.Service) *instance {
+	hostName := service.Attrs["eureka_hostname"]
+	if hostName == "" {
+		hostName = service.IP
+	}
+	datacenter := r.datacenter
+	if datacenter == "" {
+		datacenter = "MyOwn"
+	}
+	i := &instance{
+		InstanceID:       service.ID,
+		HostName:         hostName,
+		App:              appName(service.Name),
+		IPAddr:           service.IP,
+		VipAddress:       service.Name,
+		SecureVipAddress: service.Name,
+		Status:           "UP",
+		Port:             port{Port: service.Port, Enabled: "true"},
+		SecurePort:       port{Port: 443, Enabled: "false"},
+		HealthCheckURL:   absoluteURL(service, service.Attrs["eureka_health_check_url"]),
+		StatusPageURL:    absoluteURL(service, service.Attrs["eureka_status_page_url"]),
+		HomePageURL:      absoluteURL(service, service.Attrs["eureka_home_page_url"]),
+		DataCenterInfo:   dataCenterInfo{Class: dataCenterClass, Name: datacenter},
+		LeaseInfo:        leaseInfo{RenewalIntervalInSecs: 30, DurationInSecs: defaultLeaseDuration},
+		Metadata:         buildMetadata(service),
+	}
+	if secure, _ := strconv.ParseBool(service.Attrs["eureka_secure"]); secure {
+		i.Port.Enabled = "false"
+		i.SecurePort = port{Port: service.Port, Enabled: "true"}
+	}
+	if service.TTL > 0 {
+		i.LeaseInfo = leaseInfo{RenewalIntervalInSecs: max(service.TTL/3, 1), DurationInSecs: service.TTL}
+	}
+	return i
+}
+
+// absoluteURL prefixes a path given in an attribute with the service address.
+func absoluteURL(service *bridge.Service, path string) string {
+	if path == "" || strings.Contains(path, "://") {
+		return path
+	}
+	scheme := "http"
+	if secure, _ := strconv.ParseBool(service.Attrs["eureka_secure"]); secure {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s:%d%s", scheme, service.IP, service.Port, path)
+}
+
+// buildMetadata copies the service attributes, except the ones configuring
+// the instance, and records which registrator owns it.
+func buildMetadata(service *bridge.Service) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range service.Attrs {
+		if !strings.HasPrefix(k, "eureka_") {
+			meta[k] = v
+		}
+	}
+	meta[bridge.OwnerHostAttr] = bridge.Hostname
+	meta[bridge.OwnerContainerAttr] = service.Origin.ContainerName
+	return meta
+}
+
+func decodeInstance(i *instance) *bridge.Service {
+	name := i.VipAddress
+	if name == "" {
+		name = strings.ToLower(i.App)
+	}
+	p := i.Port.Port
+	if i.SecurePort.Enabled == "true" {
+		p = i.SecurePort.Port
+	}
+	return &bridge.Service{
+		ID:    i.InstanceID,
+		Name:  name,
+		IP:    i.IPAddr,
+		Port:  p,
+		Attrs: i.Metadata,
+	}
+}
+
+// do sends a JSON request to the Eureka API, decoding the JSON answer into
+// out when it is not nil.
+func (r *Eureka) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, r.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.user != nil {
+		password, _ := r.user.Password()
+		req.SetBasicAuth(r.user.Username(), password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("eureka: %s %s answered %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,207 @@
+package eureka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEureka keeps instances by app and instance ID and counts heartbeats.
+type fakeEureka struct {
+	sync.Mutex
+	apps       map[string]map[string]*instance
+	heartbeats int
+}
+
+func newFakeEureka(t *testing.T) (*fakeEureka, *Eureka) {
+	f := &fakeEureka{apps: make(map[string]map[string]*instance)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /eureka/apps", f.list)
+	mux.HandleFunc("POST /eureka/apps/{app}", f.register)
+	mux.HandleFunc("PUT /eureka/apps/{app}/{id}", f.heartbeat)
+	mux.HandleFunc("DELETE /eureka/apps/{app}/{id}", f.cancel)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	uri, err := url.Parse("eureka://" + server.Listener.Addr().String())
+	assert.NoError(t, err)
+	return f, new(Factory).New(uri).(*Eureka)
+}
+
+func (f *fakeEureka) list(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	body := new(applicationsBody)
+	for name, instances := range f.apps {
+		app := struct {
+			Name     string      `json:"name"`
+			Instance []*instance `json:"instance"`
+		}{Name: name}
+		for _, i := range instances {
+			app.Instance = append(app.Instance, i)
+		}
+		body.Applications.Application = append(body.Applications.Application, app)
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func (f *fakeEureka) register(w http.ResponseWriter, r *http.Request) {
+	body := new(instanceBody)
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil || body.Instance.App != r.PathValue("app") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.apps[body.Instance.App] == nil {
+		f.apps[body.Instance.App] = make(map[string]*instance)
+	}
+	f.apps[body.Instance.App][body.Instance.InstanceID] = body.Instance
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeEureka) heartbeat(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.apps[r.PathValue("app")][r.PathValue("id")]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.heartbeats++
+}
+
+func (f *fakeEureka) cancel(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.apps[r.PathValue("app")][r.PathValue("id")]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	delete(f.apps[r.PathValue("app")], r.PathValue("id"))
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name       string
+		service    *bridge.Service
+		port       port
+		securePort port
+		health     string
+		lease      leaseInfo
+		metadata   map[string]string
+	}{
+		{
+			name: "ttl and health check",
+			service: &bridge.Service{
+				ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30,
+				Attrs:  map[string]string{"version": "1.2", "eureka_health_check_url": "/actuator/health"},
+				Origin: bridge.ServicePort{ContainerName: "web"},
+			},
+			port:       port{Port: 8080, Enabled: "true"},
+			securePort: port{Port: 443, Enabled: "false"},
+			health:     "http://10.0.0.5:8080/actuator/health",
+			lease:      leaseInfo{RenewalIntervalInSecs: 10, DurationInSecs: 30},
+			metadata:   map[string]string{"version": "1.2"},
+		},
+		{
+			name: "secure without ttl",
+			service: &bridge.Service{
+				ID: "host:web:443", Name: "web", IP: "10.0.0.5", Port: 8443,
+				Attrs:  map[string]string{"eureka_secure": "true", "eureka_health_check_url": "/health"},
+				Origin: bridge.ServicePort{ContainerName: "web"},
+			},
+			port:       port{Port: 8443, Enabled: "false"},
+			securePort: port{Port: 8443, Enabled: "true"},
+			health:     "https://10.0.0.5:8443/health",
+			lease:      leaseInfo{RenewalIntervalInSecs: 30, DurationInSecs: defaultLeaseDuration},
+			metadata:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		f, r := newFakeEureka(t)
+		assert.NoError(t, r.Ping())
+		assert.NoError(t, r.Register(tt.service))
+
+		i := f.apps["WEB"][tt.service.ID]
+		if !assert.NotNil(t, i, tt.name) {
+			continue
+		}
+		assert.Equal(t, "10.0.0.5", i.IPAddr, tt.name)
+		assert.Equal(t, "web", i.VipAddress, tt.name)
+		assert.Equal(t, "UP", i.Status, tt.name)
+		assert.Equal(t, tt.port, i.Port, tt.name)
+		assert.Equal(t, tt.securePort, i.SecurePort, tt.name)
+		assert.Equal(t, tt.health, i.HealthCheckURL, tt.name)
+		assert.Equal(t, tt.lease, i.LeaseInfo, tt.name)
+		tt.metadata[bridge.OwnerHostAttr] = bridge.Hostname
+		tt.metadata[bridge.OwnerContainerAttr] = "web"
+		assert.Equal(t, tt.metadata, i.Metadata, tt.name)
+	}
+}
+
+func TestRefreshAndDeregister(t *testing.T) {
+	f, r := newFakeEureka(t)
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30, Attrs: map[string]string{}}
+
+	assert.NoError(t, r.Refresh(service))
+	assert.Contains(t, f.apps["WEB"], service.ID, "evicted instance is registered again")
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, 1, f.heartbeats)
+
+	assert.NoError(t, r.Deregister(service))
+	assert.Empty(t, f.apps["WEB"])
+	assert.NoError(t, r.Deregister(service))
+}
+
+func TestServices(t *testing.T) {
+	tests := []*bridge.Service{
+		{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Attrs: map[string]string{}},
+		{ID: "host:web:443", Name: "web", IP: "10.0.0.5", Port: 8443, Attrs: map[string]string{"eureka_secure": "true"}},
+		{ID: "host:Billing-API:80", Name: "Billing-API", IP: "10.0.0.6", Port: 9090, Attrs: map[string]string{"version": "2"}},
+	}
+
+	for _, service := range tests {
+		_, r := newFakeEureka(t)
+		assert.NoError(t, r.Register(service))
+
+		services, err := r.Services()
+		assert.NoError(t, err)
+		if assert.Len(t, services, 1, service.ID) {
+			assert.Equal(t, service.ID, services[0].ID)
+			assert.Equal(t, service.Name, services[0].Name)
+			assert.Equal(t, service.IP, services[0].IP)
+			assert.Equal(t, service.Port, services[0].Port)
+			assert.Equal(t, bridge.Hostname, services[0].Attrs[bridge.OwnerHostAttr])
+		}
+	}
+}
+
+func TestServicesSkipsForeignInstances(t *testing.T) {
+	f, r := newFakeEureka(t)
+	assert.NoError(t, r.Register(&bridge.Service{ID: "myhost:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Attrs: map[string]string{}}))
+	// a Spring app on the same host, whose default instance ID looks like a
+	// registrator service ID
+	f.apps["BILLING"] = map[string]*instance{
+		"myhost:billing:8080": {
+			InstanceID: "myhost:billing:8080",
+			App:        "BILLING",
+			IPAddr:     "10.0.0.5",
+			VipAddress: "billing",
+			Port:       port{Port: 8080, Enabled: "true"},
+			Metadata:   map[string]string{"management.port": "8080"},
+		},
+	}
+
+	services, err := r.Services()
+	assert.NoError(t, err)
+	if assert.Len(t, services, 1) {
+		assert.Equal(t, "myhost:web:80", services[0].ID)
+	}
+}
@@ -2,8 +2,17 @@ package main
 
 import (
 	_ "github.com/quangnguyen/registrator/consul"
+	_ "github.com/quangnguyen/registrator/consulkv"
+	_ "github.com/quangnguyen/registrator/coredns"
 	_ "github.com/quangnguyen/registrator/etcd"
+	_ "github.com/quangnguyen/registrator/eureka"
+	_ "github.com/quangnguyen/registrator/nacos"
+	_ "github.com/quangnguyen/registrator/nats"
+	_ "github.com/quangnguyen/registrator/redis"
 	_ "github.com/quangnguyen/registrator/skydns2"
+	_ "github.com/quangnguyen/registrator/slack"
+	_ "github.com/quangnguyen/registrator/teams"
 	_ "github.com/quangnguyen/registrator/telegram"
+	_ "github.com/quangnguyen/registrator/webhook"
 	_ "github.com/quangnguyen/registrator/zookeeper"
 )
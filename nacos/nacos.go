@@ -0,0 +1,355 @@
+package nacos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+const (
+	DefaultGroup   = "DEFAULT_GROUP"
+	DefaultCluster = "DEFAULT"
+
+	// IDMetadata is the instance metadata key holding the registrator service
+	// ID, since Nacos identifies instances by address.
+	IDMetadata = "registrator-id"
+
+	// Instance metadata keys overriding the Nacos heartbeat settings, in
+	// milliseconds.
+	heartBeatIntervalMetadata = "preserved.heart.beat.interval"
+	heartBeatTimeoutMetadata  = "preserved.heart.beat.timeout"
+	ipDeleteTimeoutMetadata   = "preserved.ip.delete.timeout"
+
+	// codeNotFound is the code Nacos answers a beat for an unknown instance with.
+	codeNotFound = 20404
+	pageSize     = 1000
+)
+
+func init() {
+	f := new(Factory)
+	bridge.Register(f, "nacos")
+	bridge.Register(f, "nacoss")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	base := &url.URL{Scheme: "http", Host: uri.Host, Path: strings.TrimSuffix(uri.Path, "/")}
+	if uri.Scheme == "nacoss" {
+		base.Scheme = "https"
+	}
+	if base.Path == "" {
+		base.Path = "/nacos"
+	}
+	query := uri.Query()
+	group := query.Get("group")
+	if group == "" {
+		group = DefaultGroup
+	}
+	r := &Nacos{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		base:      base.String(),
+		user:      uri.User,
+		namespace: query.Get("namespace"),
+		group:     group,
+		scopes:    make(map[scope]bool),
+	}
+	r.scopes[scope{namespace: r.namespace, group: r.group}] = true
+	return r
+}
+
+// scope is a namespace and group services are registered in.
+type scope struct {
+	namespace string
+	group     string
+}
+
+// Nacos registers services as ephemeral instances through the Nacos open API.
+type Nacos struct {
+	client    *http.Client
+	base      string
+	user      *url.Userinfo
+	namespace string
+	group     string
+
+	sync.Mutex
+	// scopes are the namespaces and groups listed by Services.
+	scopes      map[scope]bool
+	token       string
+	tokenExpiry time.Time
+}
+
+type beatResponse struct {
+	Code int `json:"code"`
+}
+
+type serviceList struct {
+	Count int      `json:"count"`
+	Doms  []string `json:"doms"`
+}
+
+type instanceList struct {
+	Hosts []struct {
+		IP       string            `json:"ip"`
+		Port     int               `json:"port"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"hosts"`
+}
+
+func (r *Nacos) Ping() error {
+	return r.do(http.MethodGet, "/v1/ns/operator/metrics", url.Values{}, nil)
+}
+
+func (r *Nacos) Register(service *bridge.Service) error {
+	s := r.scope(service)
+	params := r.instanceParams(service, s)
+	params.Set("weight", strconv.FormatFloat(weight(service), 'f', -1, 64))
+	params.Set("enabled", "true")
+	params.Set("healthy", "true")
+	metadata, err := json.Marshal(buildMetadata(service))
+	if err != nil {
+		return err
+	}
+	params.Set("metadata", string(metadata))
+	if err := r.do(http.MethodPost, "/v1/ns/instance", params, nil); err != nil {
+		log.Error("nacos: failed to register service", "serviceID", service.ID, "error", err)
+		return err
+	}
+	r.Lock()
+	r.scopes[s] = true
+	r.Unlock()
+	return nil
+}
+
+func (r *Nacos) Deregister(service *bridge.Service) error {
+	return r.do(http.MethodDelete, "/v1/ns/instance", r.instanceParams(service, r.scope(service)), nil)
+}
+
+// Refresh sends a client beat and registers the instance again when Nacos
+// no longer knows it.
+func (r *Nacos) Refresh(service *bridge.Service) error {
+	s := r.scope(service)
+	beat, err := json.Marshal(map[string]interface{}{
+		"serviceName": s.group + "@@" + service.Name,
+		"ip":          service.IP,
+		"port":        service.Port,
+		"cluster":     cluster(service),
+		"weight":      weight(service),
+		"metadata":    buildMetadata(service),
+	})
+	if err != nil {
+		return err
+	}
+	params := r.instanceParams(service, s)
+	params.Set("beat", string(beat))
+	resp := new(beatResponse)
+	if err := r.do(http.MethodPut, "/v1/ns/instance/beat", params, resp); err != nil {
+		return err
+	}
+	if resp.Code == codeNotFound {
+		log.Info("nacos: instance not found, re-registering", "serviceID", service.ID)
+		return r.Register(service)
+	}
+	return nil
+}
+
+// Services lists the instances registered by registrator, those with an
+// IDMetadata, in every namespace and group services were registered in.
+func (r *Nacos) Services() ([]*bridge.Service, error) {
+	r.Lock()
+	scopes := make([]scope, 0, len(r.scopes))
+	for s := range r.scopes {
+		scopes = append(scopes, s)
+	}
+	r.Unlock()
+
+	services := make([]*bridge.Service, 0)
+	for _, s := range scopes {
+		names, err := r.serviceNames(s)
+		if err != nil {
+			return []*bridge.Service{}, err
+		}
+		for _, name := range names {
+			params := r.scopeParams(s)
+			params.Set("serviceName", name)
+			params.Set("healthyOnly", "false")
+			instances := new(instanceList)
+			if err := r.do(http.MethodGet, "/v1/ns/instance/list", params, instances); err != nil {
+				return []*bridge.Service{}, err
+			}
+			for _, host := range instances.Hosts {
+				id := host.Metadata[IDMetadata]
+				if id == "" {
+					continue
+				}
+				services = append(services, &bridge.Service{
+					ID:    id,
+					Name:  name,
+					IP:    host.IP,
+					Port:  host.Port,
+					Attrs: host.Metadata,
+				})
+			}
+		}
+	}
+	return services, nil
+}
+
+func (r *Nacos) serviceNames(s scope) ([]string, error) {
+	names := make([]string, 0)
+	for page := 1; ; page++ {
+		params := r.scopeParams(s)
+		params.Set("pageNo", strconv.Itoa(page))
+		params.Set("pageSize", strconv.Itoa(pageSize))
+		list := new(serviceList)
+		if err := r.do(http.MethodGet, "/v1/ns/service/list", params, list); err != nil {
+			return nil, err
+		}
+		names = append(names, list.Doms...)
+		if len(list.Doms) < pageSize || len(names) >= list.Count {
+			return names, nil
+		}
+	}
+}
+
+// scope returns the namespace and group of a service, from its
+// SERVICE_NACOS_NAMESPACE and SERVICE_NACOS_GROUP attributes or the URI.
+func (r *Nacos) scope(service *bridge.Service) scope {
+	s := scope{namespace: r.namespace, group: r.group}
+	if ns := service.Attrs["nacos_namespace"]; ns != "" {
+		s.namespace = ns
+	}
+	if group := service.Attrs["nacos_group"]; group != "" {
+		s.group = group
+	}
+	return s
+}
+
+func (r *Nacos) scopeParams(s scope) url.Values {
+	params := url.Values{}
+	params.Set("groupName", s.group)
+	if s.namespace != "" {
+		params.Set("namespaceId", s.namespace)
+	}
+	return params
+}
+
+func (r *Nacos) instanceParams(service *bridge.Service, s scope) url.Values {
+	params := r.scopeParams(s)
+	params.Set("serviceName", service.Name)
+	params.Set("ip", service.IP)
+	params.Set("port", strconv.Itoa(service.Port))
+	params.Set("clusterName", cluster(service))
+	params.Set("ephemeral", "true")
+	return params
+}
+
+func cluster(service *bridge.Service) string {
+	if c := service.Attrs["nacos_cluster"]; c != "" {
+		return c
+	}
+	return DefaultCluster
+}
+
+func weight(service *bridge.Service) float64 {
+	w, err := strconv.ParseFloat(service.Attrs["weight"], 64)
+	if err != nil || w < 0 {
+		return 1
+	}
+	return w
+}
+
+// buildMetadata copies the service attributes, except the ones configuring
+// the instance, and records the service ID and which registrator owns it.
+// With a TTL, the instance turns unhealthy after the TTL without beats and
+// is removed after twice the TTL, keeping the ratios of the Nacos defaults.
+func buildMetadata(service *bridge.Service) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range service.Attrs {
+		if !strings.HasPrefix(k, "nacos_") && k != "weight" {
+			meta[k] = v
+		}
+	}
+	meta[IDMetadata] = service.ID
+	meta[bridge.OwnerHostAttr] = bridge.Hostname
+	meta[bridge.OwnerContainerAttr] = service.Origin.ContainerName
+	if service.TTL > 0 {
+		ttl := int64(service.TTL) * 1000
+		meta[heartBeatIntervalMetadata] = strconv.FormatInt(ttl/3, 10)
+		meta[heartBeatTimeoutMetadata] = strconv.FormatInt(ttl, 10)
+		meta[ipDeleteTimeoutMetadata] = strconv.FormatInt(2*ttl, 10)
+	}
+	return meta
+}
+
+// accessToken logs in with the URI credentials, reusing the token until it
+// expires. It returns "" when authentication is not configured.
+func (r *Nacos) accessToken() (string, error) {
+	if r.user == nil {
+		return "", nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.token != "" && time.Now().Before(r.tokenExpiry) {
+		return r.token, nil
+	}
+	password, _ := r.user.Password()
+	form := url.Values{"username": {r.user.Username()}, "password": {password}}
+	resp, err := r.client.PostForm(r.base+"/v1/auth/login", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("nacos: login answered %s", resp.Status)
+	}
+	login := struct {
+		AccessToken string `json:"accessToken"`
+		TokenTTL    int    `json:"tokenTtl"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		return "", err
+	}
+	r.token = login.AccessToken
+	// renew ahead of the expiry
+	r.tokenExpiry = time.Now().Add(time.Duration(login.TokenTTL) * time.Second * 9 / 10)
+	return r.token, nil
+}
+
+// do calls the open API with the parameters in the query string, decoding
+// the JSON answer into out when it is not nil.
+func (r *Nacos) do(method, path string, params url.Values, out interface{}) error {
+	token, err := r.accessToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		params.Set("accessToken", token)
+	}
+	req, err := http.NewRequest(method, r.base+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("nacos: %s %s answered %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
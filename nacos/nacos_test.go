@@ -0,0 +1,255 @@
+package nacos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeInstance struct {
+	IP       string            `json:"ip"`
+	Port     int               `json:"port"`
+	Weight   float64           `json:"weight"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// fakeNacos keeps instances by "<namespace>/<group>@@<service>" and address.
+type fakeNacos struct {
+	sync.Mutex
+	instances map[string]map[string]*fakeInstance
+	beats     int
+	logins    int
+}
+
+func newFakeNacos(t *testing.T, userinfo string) (*fakeNacos, *Nacos) {
+	f := &fakeNacos{instances: make(map[string]map[string]*fakeInstance)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /nacos/v1/auth/login", f.login)
+	mux.HandleFunc("POST /nacos/v1/ns/instance", f.register)
+	mux.HandleFunc("DELETE /nacos/v1/ns/instance", f.deregister)
+	mux.HandleFunc("PUT /nacos/v1/ns/instance/beat", f.beat)
+	mux.HandleFunc("GET /nacos/v1/ns/service/list", f.services)
+	mux.HandleFunc("GET /nacos/v1/ns/instance/list", f.list)
+	server := httptest.NewServer(f.auth(userinfo != "", mux))
+	t.Cleanup(server.Close)
+
+	uri, err := url.Parse("nacos://" + userinfo + server.Listener.Addr().String() + "?namespace=dev")
+	assert.NoError(t, err)
+	return f, new(Factory).New(uri).(*Nacos)
+}
+
+func (f *fakeNacos) auth(enabled bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if enabled && r.URL.Path != "/nacos/v1/auth/login" && r.URL.Query().Get("accessToken") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func key(q url.Values) string {
+	return q.Get("namespaceId") + "/" + q.Get("groupName") + "@@" + q.Get("serviceName")
+}
+
+func (f *fakeNacos) login(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	f.logins++
+	f.Unlock()
+	if r.FormValue("username") != "nacos" || r.FormValue("password") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.Write([]byte(`{"accessToken":"token","tokenTtl":18000}`))
+}
+
+func (f *fakeNacos) register(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	port, _ := strconv.Atoi(q.Get("port"))
+	weight, _ := strconv.ParseFloat(q.Get("weight"), 64)
+	i := &fakeInstance{IP: q.Get("ip"), Port: port, Weight: weight}
+	if err := json.Unmarshal([]byte(q.Get("metadata")), &i.Metadata); err != nil || q.Get("ephemeral") != "true" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.instances[key(q)] == nil {
+		f.instances[key(q)] = make(map[string]*fakeInstance)
+	}
+	f.instances[key(q)][q.Get("ip")+":"+q.Get("port")] = i
+	w.Write([]byte("ok"))
+}
+
+func (f *fakeNacos) deregister(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f.Lock()
+	defer f.Unlock()
+	delete(f.instances[key(q)], q.Get("ip")+":"+q.Get("port"))
+	w.Write([]byte("ok"))
+}
+
+func (f *fakeNacos) beat(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.instances[key(q)][q.Get("ip")+":"+q.Get("port")]; !ok {
+		w.Write([]byte(`{"code":20404}`))
+		return
+	}
+	f.beats++
+	w.Write([]byte(`{"clientBeatInterval":5000,"code":10200}`))
+}
+
+func (f *fakeNacos) services(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("namespaceId") + "/" + q.Get("groupName") + "@@"
+	f.Lock()
+	defer f.Unlock()
+	list := &serviceList{Doms: []string{}}
+	for k := range f.instances {
+		if len(k) > len(prefix) && k[:len(prefix)] == prefix {
+			list.Doms = append(list.Doms, k[len(prefix):])
+		}
+	}
+	list.Count = len(list.Doms)
+	json.NewEncoder(w).Encode(list)
+}
+
+func (f *fakeNacos) list(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	hosts := make([]*fakeInstance, 0)
+	for _, i := range f.instances[key(r.URL.Query())] {
+		hosts = append(hosts, i)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"hosts": hosts})
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		attrs    map[string]string
+		key      string
+		weight   float64
+		metadata map[string]string
+	}{
+		{
+			name:     "uri scope",
+			attrs:    map[string]string{"version": "1.2", "weight": "2.5"},
+			key:      "dev/DEFAULT_GROUP@@web",
+			weight:   2.5,
+			metadata: map[string]string{"version": "1.2"},
+		},
+		{
+			name:     "scope from attrs",
+			attrs:    map[string]string{"nacos_group": "payments", "nacos_namespace": "prod", "nacos_cluster": "eu"},
+			key:      "prod/payments@@web",
+			weight:   1,
+			metadata: map[string]string{},
+		},
+		{
+			name:     "invalid weight",
+			attrs:    map[string]string{"weight": "-1"},
+			key:      "dev/DEFAULT_GROUP@@web",
+			weight:   1,
+			metadata: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		f, r := newFakeNacos(t, "")
+		service := &bridge.Service{
+			ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080,
+			Attrs:  tt.attrs,
+			Origin: bridge.ServicePort{ContainerName: "web"},
+		}
+		assert.NoError(t, r.Register(service), tt.name)
+
+		i := f.instances[tt.key]["10.0.0.5:8080"]
+		if !assert.NotNil(t, i, tt.name) {
+			continue
+		}
+		assert.Equal(t, tt.weight, i.Weight, tt.name)
+		tt.metadata[IDMetadata] = "host:web:80"
+		tt.metadata[bridge.OwnerHostAttr] = bridge.Hostname
+		tt.metadata[bridge.OwnerContainerAttr] = "web"
+		assert.Equal(t, tt.metadata, i.Metadata, tt.name)
+
+		services, err := r.Services()
+		assert.NoError(t, err)
+		if assert.Len(t, services, 1, tt.name) {
+			assert.Equal(t, "host:web:80", services[0].ID)
+		}
+	}
+}
+
+func TestRefreshAndDeregister(t *testing.T) {
+	f, r := newFakeNacos(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Attrs: map[string]string{}}
+
+	assert.NoError(t, r.Refresh(service))
+	assert.Contains(t, f.instances["dev/DEFAULT_GROUP@@web"], "10.0.0.5:8080", "unknown instance is registered again")
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, 1, f.beats)
+
+	assert.NoError(t, r.Deregister(service))
+	assert.Empty(t, f.instances["dev/DEFAULT_GROUP@@web"])
+}
+
+func TestServicesSkipsForeignInstances(t *testing.T) {
+	f, r := newFakeNacos(t, "")
+	assert.NoError(t, r.Register(&bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Attrs: map[string]string{}}))
+	f.instances["dev/DEFAULT_GROUP@@legacy"] = map[string]*fakeInstance{"10.0.0.9:80": {IP: "10.0.0.9", Port: 80}}
+
+	services, err := r.Services()
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, &bridge.Service{
+		ID:    "host:web:80",
+		Name:  "web",
+		IP:    "10.0.0.5",
+		Port:  8080,
+		Attrs: f.instances["dev/DEFAULT_GROUP@@web"]["10.0.0.5:8080"].Metadata,
+	}, services[0])
+}
+
+func TestAccessToken(t *testing.T) {
+	f, r := newFakeNacos(t, "nacos:secret@")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Attrs: map[string]string{}}
+	assert.NoError(t, r.Register(service))
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, 1, f.logins)
+}
+
+func TestBuildMetadataHeartbeat(t *testing.T) {
+	tests := []struct {
+		ttl      int
+		expected map[string]string
+	}{
+		{ttl: 0, expected: map[string]string{}},
+		{ttl: 30, expected: map[string]string{
+			heartBeatIntervalMetadata: "10000",
+			heartBeatTimeoutMetadata:  "30000",
+			ipDeleteTimeoutMetadata:   "60000",
+		}},
+	}
+
+	for _, tt := range tests {
+		meta := buildMetadata(&bridge.Service{ID: "host:web:80", TTL: tt.ttl})
+		for _, k := range []string{heartBeatIntervalMetadata, heartBeatTimeoutMetadata, ipDeleteTimeoutMetadata} {
+			if v, ok := tt.expected[k]; ok {
+				assert.Equal(t, v, meta[k], k)
+			} else {
+				assert.NotContains(t, meta, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/payload"
+)
+
+const (
+	DefaultBucket = "services"
+	timeout       = 10 * time.Second
+)
+
+func init() {
+	f := new(Factory)
+	bridge.Register(f, "nats")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	servers := make([]string, 0)
+	for _, host := range strings.Split(uri.Host, ",") {
+		servers = append(servers, uri.Scheme+"://"+host)
+	}
+	options := []nats.Option{
+		nats.Name("registrator"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if uri.User != nil {
+		if password, ok := uri.User.Password(); ok {
+			options = append(options, nats.UserInfo(uri.User.Username(), password))
+		} else {
+			options = append(options, nats.Token(uri.User.Username()))
+		}
+	}
+	if creds := os.Getenv("NATS_CREDS"); creds != "" {
+		options = append(options, nats.UserCredentials(creds))
+	}
+	conn, err := nats.Connect(strings.Join(servers, ","), options...)
+	if err != nil {
+		log.Error("nats: cannot connect", "error", err)
+	}
+
+	query := uri.Query()
+	config := jetstream.KeyValueConfig{
+		Bucket:      query.Get("bucket"),
+		Description: "Services registered by registrator",
+		History:     1,
+	}
+	if config.Bucket == "" {
+		config.Bucket = DefaultBucket
+	}
+	if ttl := query.Get("ttl"); ttl != "" {
+		if config.TTL, err = time.ParseDuration(ttl); err != nil {
+			log.Error("nats: invalid bucket TTL", "error", err)
+		}
+	}
+	if replicas := query.Get("replicas"); replicas != "" {
+		if config.Replicas, err = strconv.Atoi(replicas); err != nil {
+			log.Error("nats: invalid bucket replicas", "error", err)
+		}
+	}
+	return &Nats{
+		conn:    conn,
+		config:  config,
+		subject: query.Get("subject"),
+	}
+}
+
+// Nats stores services as JSON payload.Values in a JetStream key-value bucket, under
+// <name>.<id> with characters keys do not allow replaced by underscores.
+type Nats struct {
+	conn    *nats.Conn
+	config  jetstream.KeyValueConfig
+	subject string
+
+	sync.Mutex
+	kv jetstream.KeyValue
+}
+
+// bucket creates the bucket, or updates its TTL, on first use.
+func (r *Nats) bucket(ctx context.Context) (jetstream.KeyValue, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.kv != nil {
+		return r.kv, nil
+	}
+	if r.conn == nil {
+		return nil, errors.New("nats: not connected")
+	}
+	js, err := jetstream.New(r.conn)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := js.CreateOrUpdateKeyValue(ctx, r.config)
+	if err != nil {
+		return nil, err
+	}
+	r.kv = kv
+	return kv, nil
+}
+
+func (r *Nats) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	kv, err := r.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = kv.Status(ctx)
+	return err
+}
+
+func (r *Nats) Register(service *bridge.Service) error {
+	if err := r.put(service); err != nil {
+		log.Error("nats: failed to register service", "serviceID", service.ID, "error", err)
+		return err
+	}
+	r.publish("register", service)
+	return nil
+}
+
+func (r *Nats) put(service *bridge.Service) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	kv, err := r.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(payload.New(service))
+	if err != nil {
+		return err
+	}
+	_, err = kv.Put(ctx, key(service), data)
+	return err
+}
+
+func (r *Nats) Deregister(service *bridge.Service) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	kv, err := r.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	if err := kv.Purge(ctx, key(service)); err != nil {
+		log.Error("nats: failed to deregister service", "serviceID", service.ID, "error", err)
+		return err
+	}
+	r.publish("deregister", service)
+	return nil
+}
+
+// Refresh writes the service again, which restarts the bucket TTL.
+func (r *Nats) Refresh(service *bridge.Service) error {
+	if r.config.TTL <= 0 {
+		return nil
+	}
+	return r.put(service)
+}
+
+func (r *Nats) Services() ([]*bridge.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	kv, err := r.bucket(ctx)
+	if err != nil {
+		return []*bridge.Service{}, err
+	}
+	keys, err := kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return []*bridge.Service{}, nil
+	}
+	if err != nil {
+		return []*bridge.Service{}, err
+	}
+	services := make([]*bridge.Service, 0, len(keys))
+	for _, k := range keys {
+		entry, err := kv.Get(ctx, k)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return []*bridge.Service{}, err
+		}
+		service, err := payload.Decode(entry.Value())
+		if err != nil {
+			log.Error("nats: failed to decode service", "key", k, "error", err)
+			continue
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func (r *Nats) publish(name string, service *bridge.Service) {
+	if r.subject == "" {
+		return
+	}
+	data, err := json.Marshal(&payload.Event{Event: name, Value: payload.New(service)})
+	if err != nil {
+		return
+	}
+	if err := r.conn.Publish(r.subject, data); err != nil {
+		log.Error("nats: failed to publish event", "subject", r.subject, "error", err)
+	}
+}
+
+func key(service *bridge.Service) string {
+	return sanitize(service.Name) + "." + sanitize(service.ID)
+}
+
+// sanitize replaces the characters not allowed in a key token.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '=' {
+			return r
+		}
+		return '_'
+	}, s)
+}
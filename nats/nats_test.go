@@ -0,0 +1,125 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNats(t *testing.T, query string) *Nats {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	assert.NoError(t, err)
+	s.Start()
+	t.Cleanup(s.Shutdown)
+	assert.True(t, s.ReadyForConnections(5*time.Second))
+
+	uri, err := url.Parse(s.ClientURL() + "?" + query)
+	assert.NoError(t, err)
+	r := new(Factory).New(uri).(*Nats)
+	t.Cleanup(r.conn.Close)
+	assert.NoError(t, r.Ping())
+	return r
+}
+
+func TestRegisterAndServices(t *testing.T) {
+	tests := []struct {
+		service *bridge.Service
+		key     string
+		stored  string
+	}{
+		{
+			service: &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Tags: []string{"prod"}, Attrs: map[string]string{"version": "1.2"}},
+			key:     "web.host_web_80",
+			stored:  `{"id":"host:web:80","name":"web","ip":"10.0.0.5","port":8080,"tags":["prod"],"attrs":{"version":"1.2"}}`,
+		},
+		{
+			service: &bridge.Service{ID: "host:my.api:9090:udp", Name: "my.api", IP: "10.0.0.6", Port: 9090},
+			key:     "my_api.host_my_api_9090_udp",
+			stored:  `{"id":"host:my.api:9090:udp","name":"my.api","ip":"10.0.0.6","port":9090,"tags":null,"attrs":null}`,
+		},
+	}
+
+	r := newTestNats(t, "bucket=discovery")
+	for _, tt := range tests {
+		services, err := r.Services()
+		assert.NoError(t, err)
+		assert.Empty(t, services)
+
+		assert.NoError(t, r.Register(tt.service))
+		entry, err := r.kv.Get(context.Background(), tt.key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, tt.stored, string(entry.Value()))
+
+		services, err = r.Services()
+		assert.NoError(t, err)
+		assert.Equal(t, []*bridge.Service{tt.service}, services)
+
+		assert.NoError(t, r.Deregister(tt.service))
+	}
+}
+
+func TestServicesSkipsMalformed(t *testing.T) {
+	r := newTestNats(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080}
+	assert.NoError(t, r.Register(service))
+	for k, v := range map[string]string{"garbage": "x", "empty": "{}"} {
+		_, err := r.kv.Put(context.Background(), k, []byte(v))
+		assert.NoError(t, err)
+	}
+
+	services, err := r.Services()
+	assert.NoError(t, err)
+	assert.Equal(t, []*bridge.Service{service}, services)
+}
+
+func TestBucketTTL(t *testing.T) {
+	r := newTestNats(t, "ttl=1s")
+	status, err := r.kv.Status(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, status.TTL())
+
+	assert.NoError(t, r.Register(&bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080}))
+	assert.Eventually(t, func() bool {
+		services, err := r.Services()
+		return err == nil && len(services) == 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestEvents(t *testing.T) {
+	r := newTestNats(t, "subject=registrator.events")
+	sub, err := r.conn.SubscribeSync("registrator.events")
+	assert.NoError(t, err)
+
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080}
+	assert.NoError(t, r.Register(service))
+	assert.NoError(t, r.Deregister(service))
+	for _, expected := range []string{"register", "deregister"} {
+		msg, err := sub.NextMsg(time.Second)
+		assert.NoError(t, err)
+		e := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(msg.Data, &e))
+		assert.Equal(t, expected, e["event"])
+		assert.Equal(t, "host:web:80", e["id"])
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		service *bridge.Service
+		key     string
+	}{
+		{service: &bridge.Service{Name: "my.svc", ID: "host:web-1:80"}, key: "my_svc.host_web-1_80"},
+		{service: &bridge.Service{Name: "a b", ID: "*>"}, key: "a_b.__"},
+		{service: &bridge.Service{Name: "web", ID: "a=b"}, key: "web.a=b"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.key, key(tt.service))
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+// flapFilter holds back offline notifications for the debounce window. If the
+// service comes back online within it, both notifications are dropped and the
+// restart is counted; counts are reported once per flap window.
+type flapFilter struct {
+	debounce   time.Duration
+	flapWindow time.Duration
+
+	pending     map[string]*pendingOffline
+	restarts    map[string]*restarts
+	windowStart time.Time
+}
+
+type restarts struct {
+	count   int
+	service *bridge.Service
+}
+
+type pendingOffline struct {
+	notification *Notification
+	deadline     time.Time
+}
+
+func newFlapFilter(debounce, flapWindow time.Duration) *flapFilter {
+	return &flapFilter{
+		debounce:   debounce,
+		flapWindow: flapWindow,
+		pending:    make(map[string]*pendingOffline),
+		restarts:   make(map[string]*restarts),
+	}
+}
+
+// add returns the notifications that can be sent right away.
+func (f *flapFilter) add(n *Notification, now time.Time) []*Notification {
+	if f.debounce <= 0 {
+		return []*Notification{n}
+	}
+	if !n.Online {
+		f.pending[n.Service.ID] = &pendingOffline{notification: n, deadline: now.Add(f.debounce)}
+		return nil
+	}
+	if _, ok := f.pending[n.Service.ID]; ok {
+		delete(f.pending, n.Service.ID)
+		if len(f.restarts) == 0 {
+			f.windowStart = now
+		}
+		r, ok := f.restarts[n.Service.Name]
+		if !ok {
+			r = &restarts{}
+			f.restarts[n.Service.Name] = r
+		}
+		r.count++
+		r.service = n.Service
+		return nil
+	}
+	return []*Notification{n}
+}
+
+// expire returns the offline notifications whose debounce window has passed.
+func (f *flapFilter) expire(now time.Time) []*Notification {
+	expired := make([]*Notification, 0)
+	for id, p := range f.pending {
+		if !now.Before(p.deadline) {
+			expired = append(expired, p.notification)
+			delete(f.pending, id)
+		}
+	}
+	return expired
+}
+
+// summaries returns a notification per flapping service once the flap window
+// is over. Their text is not escaped yet.
+func (f *flapFilter) summaries(now time.Time) []*Notification {
+	if len(f.restarts) == 0 || now.Sub(f.windowStart) < f.flapWindow {
+		return nil
+	}
+	names := make([]string, 0, len(f.restarts))
+	for name := range f.restarts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	summaries := make([]*Notification, 0, len(names))
+	for _, name := range names {
+		r := f.restarts[name]
+		summaries = append(summaries, &Notification{
+			Online:  true,
+			Service: r.service,
+			Text:    fmt.Sprintf("%s restarted %d times in %s", name, r.count, shortDuration(f.flapWindow)),
+		})
+	}
+	f.restarts = make(map[string]*restarts)
+	return summaries
+}
+
+// shortDuration formats 5m0s as 5m and 1h0m0s as 1h.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func texts(notifications []*Notification) []string {
+	out := make([]string, 0, len(notifications))
+	for _, n := range notifications {
+		out = append(out, n.Text)
+	}
+	return out
+}
+
+func TestFlapFilterDisabled(t *testing.T) {
+	f := newFlapFilter(0, time.Minute)
+	service := &bridge.Service{ID: "host:api:80", Name: "api"}
+	now := time.Now()
+
+	assert.Equal(t, []string{"OFFLINE"}, texts(f.add(&Notification{Service: service, Text: "OFFLINE"}, now)))
+	assert.Equal(t, []string{"ONLINE"}, texts(f.add(&Notification{Online: true, Service: service, Text: "ONLINE"}, now)))
+}
+
+func TestFlapFilterCollapsesRestarts(t *testing.T) {
+	f := newFlapFilter(10*time.Second, 5*time.Minute)
+	service := &bridge.Service{ID: "host:api:80", Name: "api"}
+	start := time.Now()
+
+	assert.Equal(t, []string{"ONLINE"}, texts(f.add(&Notification{Online: true, Service: service, Text: "ONLINE"}, start)))
+	for i := 0; i < 12; i++ {
+		now := start.Add(time.Duration(i) * 20 * time.Second)
+		assert.Empty(t, f.add(&Notification{Service: service, Text: "OFFLINE"}, now))
+		assert.Empty(t, f.add(&Notification{Online: true, Service: service, Text: "ONLINE"}, now.Add(3*time.Second)))
+		assert.Empty(t, f.expire(now.Add(5*time.Second)))
+	}
+
+	assert.Empty(t, f.summaries(start.Add(time.Minute)))
+	summaries := f.summaries(start.Add(6 * time.Minute))
+	assert.Equal(t, []string{"api restarted 12 times in 5m"}, texts(summaries))
+	assert.Equal(t, service, summaries[0].Service)
+	assert.Empty(t, f.summaries(start.Add(12*time.Minute)))
+}
+
+func TestFlapFilterReleasesOffline(t *testing.T) {
+	f := newFlapFilter(10*time.Second, 5*time.Minute)
+	service := &bridge.Service{ID: "host:api:80", Name: "api"}
+	now := time.Now()
+
+	assert.Empty(t, f.add(&Notification{Service: service, Text: "OFFLINE"}, now))
+	assert.Empty(t, f.expire(now.Add(9*time.Second)))
+	assert.Equal(t, []string{"OFFLINE"}, texts(f.expire(now.Add(10*time.Second))))
+	assert.Equal(t, []string{"ONLINE"}, texts(f.add(&Notification{Online: true, Service: service, Text: "ONLINE"}, now.Add(11*time.Second))))
+}
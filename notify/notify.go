@@ -0,0 +1,269 @@
+// Package notify is the core shared by the chat notification backends. It
+// tracks registered services, renders online and offline messages from
+// templates, holds back flapping services and batches what is left.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+const (
+	DefaultQueueSize  = 100
+	DefaultFlapWindow = 5 * time.Minute
+	MaxSendAttempts   = 5
+)
+
+// params are the query parameters read by Options, which backends posting to
+// a webhook URL do not pass on.
+var params = []string{"online_template", "offline_template", "debounce", "flap_window", "queue_size"}
+
+// Notification is a rendered message waiting in the queue.
+type Notification struct {
+	Online  bool
+	Service *bridge.Service
+	Text    string
+}
+
+// Flusher sends a batch of notifications. dropped is the number of
+// notifications dropped since the previous batch because the queue was full.
+type Flusher func(batch []*Notification, dropped int64)
+
+type Options struct {
+	// Name is the backend name used in log messages.
+	Name            string
+	OnlineTemplate  string
+	OfflineTemplate string
+	// Escape quotes text for the backend's markup.
+	Escape     func(string) string
+	Debounce   time.Duration
+	FlapWindow time.Duration
+	QueueSize  int
+}
+
+// ParseOptions reads the options common to all backends from the URI query,
+// falling back to environment variables named <prefix>_ONLINE_TEMPLATE,
+// <prefix>_DEBOUNCE and so on.
+func ParseOptions(name string, query url.Values, prefix string, escape func(string) string) Options {
+	queueSize, err := strconv.Atoi(Setting(query, "queue_size", prefix+"_QUEUE_SIZE", strconv.Itoa(DefaultQueueSize)))
+	if err != nil || queueSize <= 0 {
+		log.Error("Invalid queue size, using default", "backend", name, "error", err)
+		queueSize = DefaultQueueSize
+	}
+	return Options{
+		Name:            name,
+		OnlineTemplate:  Setting(query, "online_template", prefix+"_ONLINE_TEMPLATE", DefaultOnlineTemplate),
+		OfflineTemplate: Setting(query, "offline_template", prefix+"_OFFLINE_TEMPLATE", DefaultOfflineTemplate),
+		Escape:          escape,
+		Debounce:        Duration(query, "debounce", prefix+"_DEBOUNCE", 0),
+		FlapWindow:      Duration(query, "flap_window", prefix+"_FLAP_WINDOW", DefaultFlapWindow),
+		QueueSize:       queueSize,
+	}
+}
+
+// Setting returns a URI query parameter, falling back to an environment variable and a default.
+func Setting(query url.Values, param, env, default_ string) string {
+	if v := query.Get(param); v != "" {
+		return v
+	}
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return default_
+}
+
+func Duration(query url.Values, param, env string, default_ time.Duration) time.Duration {
+	v := Setting(query, param, env, "")
+	if v == "" {
+		return default_
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Error("Invalid duration, using default", "param", param, "error", err)
+		return default_
+	}
+	return d
+}
+
+// WebhookURL turns a slack:// or teams:// URI into the https URL of the
+// incoming webhook, keeping query parameters that are not notifier options.
+func WebhookURL(uri *url.URL) string {
+	query := uri.Query()
+	for _, p := range params {
+		query.Del(p)
+	}
+	return (&url.URL{Scheme: "https", Host: uri.Host, Path: uri.Path, RawQuery: query.Encode()}).String()
+}
+
+// Notifier implements the bridge.RegistryAdapter methods of a notification
+// backend other than Ping. Backends embed it and send the batches it hands
+// to their Flusher.
+type Notifier struct {
+	name    string
+	online  *template.Template
+	offline *template.Template
+	escape  func(string) string
+	flaps   *flapFilter
+	queue   chan *Notification
+	dropped atomic.Int64
+	flush   Flusher
+
+	// services holds the registered services by ID.
+	services sync.Map
+}
+
+// New creates a notifier. Invalid templates are replaced by the defaults. The
+// queue is not processed until Run is called.
+func New(opts Options, flush Flusher) *Notifier {
+	if opts.Escape == nil {
+		opts.Escape = func(s string) string { return s }
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	online, err := newTemplate("online", opts.Escape, opts.OnlineTemplate)
+	if err != nil {
+		log.Error("Invalid online template, using default", "backend", opts.Name, "error", err)
+		online, _ = newTemplate("online", opts.Escape, DefaultOnlineTemplate)
+	}
+	offline, err := newTemplate("offline", opts.Escape, opts.OfflineTemplate)
+	if err != nil {
+		log.Error("Invalid offline template, using default", "backend", opts.Name, "error", err)
+		offline, _ = newTemplate("offline", opts.Escape, DefaultOfflineTemplate)
+	}
+	return &Notifier{
+		name:    opts.Name,
+		online:  online,
+		offline: offline,
+		escape:  opts.Escape,
+		flaps:   newFlapFilter(opts.Debounce, opts.FlapWindow),
+		queue:   make(chan *Notification, opts.QueueSize),
+		flush:   flush,
+	}
+}
+
+// Escape quotes text for the backend's markup.
+func (n *Notifier) Escape(text string) string {
+	return n.escape(text)
+}
+
+func (n *Notifier) Register(service *bridge.Service) error {
+	if _, loaded := n.services.LoadOrStore(service.ID, service); loaded {
+		return nil
+	}
+	message, err := render(n.online, "ONLINE", service)
+	if err != nil {
+		n.services.Delete(service.ID)
+		return err
+	}
+	n.enqueue(&Notification{Online: true, Service: service, Text: message})
+	return nil
+}
+
+func (n *Notifier) Deregister(service *bridge.Service) error {
+	if _, loaded := n.services.LoadAndDelete(service.ID); !loaded {
+		return nil
+	}
+	message, err := render(n.offline, "OFFLINE", service)
+	if err != nil {
+		return err
+	}
+	n.enqueue(&Notification{Online: false, Service: service, Text: message})
+	return nil
+}
+
+// enqueue never blocks the bridge: when the queue is full the notification is
+// dropped and counted, and the count is reported with the next batch.
+func (n *Notifier) enqueue(notification *Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		dropped := n.dropped.Add(1)
+		log.Error("Message queue is full, dropping notification", "backend", n.name, "serviceID", notification.Service.ID, "dropped", dropped)
+	}
+}
+
+func (n *Notifier) Refresh(_ *bridge.Service) error {
+	return nil
+}
+
+func (n *Notifier) Services() ([]*bridge.Service, error) {
+	services := make([]*bridge.Service, 0)
+	n.services.Range(func(_, service interface{}) bool {
+		services = append(services, service.(*bridge.Service))
+		return true
+	})
+	return services, nil
+}
+
+// Run processes the queue, flushing a batch at most once per second.
+func (n *Notifier) Run() {
+	batch := make([]*Notification, 0)
+	timer := time.NewTimer(time.Second * 5)
+	for {
+		select {
+		case notification := <-n.queue:
+			batch = append(batch, n.flaps.add(notification, time.Now())...)
+		case <-timer.C:
+			now := time.Now()
+			batch = append(batch, n.flaps.expire(now)...)
+			for _, summary := range n.flaps.summaries(now) {
+				summary.Text = n.escape(summary.Text)
+				batch = append(batch, summary)
+			}
+			if dropped := n.dropped.Swap(0); len(batch) > 0 || dropped > 0 {
+				n.flush(batch, dropped)
+				batch = make([]*Notification, 0)
+			}
+			timer.Reset(time.Second * 1)
+		}
+	}
+}
+
+// DroppedText is the message reporting dropped notifications.
+func DroppedText(dropped int64) string {
+	return fmt.Sprintf("%d notifications dropped", dropped)
+}
+
+// PostJSON posts a JSON payload to a webhook URL, waiting as long as the
+// server asks in Retry-After when it answers 429 Too Many Requests.
+func PostJSON(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < MaxSendAttempts {
+			retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+			if err != nil || retryAfter <= 0 {
+				retryAfter = 1
+			}
+			log.Info("Rate limit reached, retrying", "retryAfter", retryAfter)
+			time.Sleep(time.Duration(retryAfter) * time.Second)
+			continue
+		}
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+}
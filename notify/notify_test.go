@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNotifier(queueSize int) *Notifier {
+	return New(Options{
+		OnlineTemplate:  `ONLINE {{ .Service.ID }}`,
+		OfflineTemplate: `OFFLINE {{ .Service.ID }}`,
+		QueueSize:       queueSize,
+	}, nil)
+}
+
+func drain(queue chan *Notification) []string {
+	messages := make([]string, 0)
+	for {
+		select {
+		case n := <-queue:
+			messages = append(messages, n.Text)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestReplicasTrackedByID(t *testing.T) {
+	n := newTestNotifier(10)
+	first := &bridge.Service{ID: "host:api-1:80", Name: "api"}
+	second := &bridge.Service{ID: "host:api-2:80", Name: "api"}
+
+	assert.NoError(t, n.Register(first))
+	assert.NoError(t, n.Register(second))
+	assert.NoError(t, n.Register(first))
+	assert.Equal(t, []string{"ONLINE host:api-1:80", "ONLINE host:api-2:80"}, drain(n.queue))
+
+	services, err := n.Services()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*bridge.Service{first, second}, services)
+
+	assert.NoError(t, n.Deregister(second))
+	assert.NoError(t, n.Deregister(second))
+	assert.NoError(t, n.Deregister(first))
+	assert.Equal(t, []string{"OFFLINE host:api-2:80", "OFFLINE host:api-1:80"}, drain(n.queue))
+
+	services, err = n.Services()
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func TestAdaptersDoNotShareState(t *testing.T) {
+	a, b := newTestNotifier(10), newTestNotifier(10)
+	assert.NoError(t, a.Register(&bridge.Service{ID: "host:api-1:80", Name: "api"}))
+
+	services, err := b.Services()
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func TestEnqueueDoesNotBlock(t *testing.T) {
+	n := newTestNotifier(1)
+	assert.NoError(t, n.Register(&bridge.Service{ID: "host:api-1:80", Name: "api"}))
+	assert.NoError(t, n.Register(&bridge.Service{ID: "host:api-2:80", Name: "api"}))
+	assert.NoError(t, n.Register(&bridge.Service{ID: "host:api-3:80", Name: "api"}))
+
+	assert.Equal(t, []string{"ONLINE host:api-1:80"}, drain(n.queue))
+	assert.Equal(t, int64(2), n.dropped.Load())
+}
+
+func TestInvalidTemplateFallsBackToDefault(t *testing.T) {
+	n := New(Options{OnlineTemplate: `{{ .Nope`, OfflineTemplate: DefaultOfflineTemplate}, nil)
+	assert.NoError(t, n.Register(&bridge.Service{ID: "host:api-1:80", Name: "api", IP: "10.0.0.5"}))
+	assert.Equal(t, []string{"ONLINE: Service api with ip 10.0.0.5 goes online"}, drain(n.queue))
+}
+
+func TestWebhookURL(t *testing.T) {
+	uri, _ := url.Parse("teams://example.webhook.office.com/workflows/abc?sig=xyz&debounce=30s")
+	assert.Equal(t, "https://example.webhook.office.com/workflows/abc?sig=xyz", WebhookURL(uri))
+}
+
+func TestPostJSONRetriesRateLimit(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	assert.NoError(t, PostJSON(server.Client(), server.URL, map[string]string{"text": "hi"}))
+	assert.Equal(t, int32(2), requests.Load())
+}
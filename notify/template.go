@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+const (
+	DefaultOnlineTemplate  = `ONLINE: Service {{ .Service.Name | escape }} with ip {{ .Service.IP | escape }} goes online`
+	DefaultOfflineTemplate = `OFFLINE: Service {{ .Service.Name | escape }} with ip {{ .Service.IP | escape }} goes offline`
+)
+
+// messageData is what message templates are executed with.
+type messageData struct {
+	Event     string
+	Service   *bridge.Service
+	Host      string
+	Container string
+}
+
+// newTemplate parses a message template. Templates can use escape to quote
+// values for the backend's markup, and join to format tags.
+func newTemplate(name string, escape func(string) string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"escape": escape,
+		"join":   strings.Join,
+	}).Parse(text)
+}
+
+func render(tmpl *template.Template, event string, service *bridge.Service) (string, error) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, &messageData{
+		Event:     event,
+		Service:   service,
+		Host:      bridge.Hostname,
+		Container: service.Origin.ContainerName,
+	})
+	return b.String(), err
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+var testService = &bridge.Service{
+	ID:    "host:api-1:80",
+	Name:  "api",
+	IP:    "10.0.0.5",
+	Port:  8080,
+	Tags:  []string{"prod", "eu"},
+	Attrs: map[string]string{"owner": "team-a"},
+	Origin: bridge.ServicePort{
+		ContainerName: "api-1",
+	},
+}
+
+var markdownEscape = strings.NewReplacer("-", `\-`, "*", `\*`).Replace
+
+func TestDefaultTemplates(t *testing.T) {
+	online, err := newTemplate("online", markdownEscape, DefaultOnlineTemplate)
+	assert.NoError(t, err)
+	message, err := render(online, "ONLINE", testService)
+	assert.NoError(t, err)
+	assert.Equal(t, "ONLINE: Service api with ip 10.0.0.5 goes online", message)
+}
+
+func TestCustomTemplate(t *testing.T) {
+	tmpl, err := newTemplate("online", markdownEscape,
+		`*{{ .Event }}* {{ escape .Service.Name }} {{ escape .Container }} {{ .Service.Port }} [{{ join .Service.Tags "," }}] {{ index .Service.Attrs "owner" | escape }}`)
+	assert.NoError(t, err)
+	message, err := render(tmpl, "ONLINE", testService)
+	assert.NoError(t, err)
+	assert.Equal(t, `*ONLINE* api api\-1 8080 [prod,eu] team\-a`, message)
+}
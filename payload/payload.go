@@ -0,0 +1,54 @@
+// Package payload is the JSON form of a service stored by the key-value
+// backends and published with their change events.
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+// Value is the JSON stored for each service.
+type Value struct {
+	ID    string            `json:"id"`
+	Name  string            `json:"name"`
+	IP    string            `json:"ip"`
+	Port  int               `json:"port"`
+	Tags  []string          `json:"tags"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+// Event is a change event, the value with the event name added.
+type Event struct {
+	Event string `json:"event"`
+	*Value
+}
+
+func New(service *bridge.Service) *Value {
+	return &Value{
+		ID:    service.ID,
+		Name:  service.Name,
+		IP:    service.IP,
+		Port:  service.Port,
+		Tags:  service.Tags,
+		Attrs: service.Attrs,
+	}
+}
+
+func (v *Value) Service() *bridge.Service {
+	return &bridge.Service{ID: v.ID, Name: v.Name, IP: v.IP, Port: v.Port, Tags: v.Tags, Attrs: v.Attrs}
+}
+
+// Decode returns the service stored as data, failing for JSON that is not
+// a service value.
+func Decode(data []byte) (*bridge.Service, error) {
+	v := new(Value)
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	if v.ID == "" || v.Name == "" {
+		return nil, errors.New("not a service")
+	}
+	return v.Service(), nil
+}
@@ -0,0 +1,40 @@
+package payload
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []*bridge.Service{
+		{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Tags: []string{"a"}, Attrs: map[string]string{"region": "eu"}},
+		{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080},
+	}
+
+	for _, service := range tests {
+		data, err := json.Marshal(New(service))
+		assert.NoError(t, err)
+		decoded, err := Decode(data)
+		assert.NoError(t, err)
+		assert.Equal(t, service, decoded)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Tags: []string{"a"}}
+	data, err := json.Marshal(&Event{Event: "register", Value: New(service)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"event":"register","id":"host:web:80","name":"web","ip":"10.0.0.5","port":8080,"tags":["a"],"attrs":null}`, string(data))
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []string{"", "garbage", `{"id":"host:web:80"}`, `{"name":"web"}`, `{"id":1}`}
+
+	for _, data := range tests {
+		_, err := Decode([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,237 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/payload"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultPrefix = "services"
+	scanCount     = 100
+)
+
+// params are the query parameters read by the adapter, which go-redis does
+// not accept.
+var params = []string{"prefix", "format", "channel"}
+
+func init() {
+	f := new(Factory)
+	bridge.Register(f, "redis")
+	bridge.Register(f, "rediss")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	query := uri.Query()
+	clientURI := *uri
+	clientQuery := uri.Query()
+	for _, p := range params {
+		clientQuery.Del(p)
+	}
+	clientURI.RawQuery = clientQuery.Encode()
+	options, err := redis.ParseURL(clientURI.String())
+	if err != nil {
+		log.Error("redis: invalid URI", "error", err)
+		options = &redis.Options{Addr: uri.Host}
+	}
+
+	prefix := query.Get("prefix")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	channel := query.Get("channel")
+	if channel == "" {
+		channel = prefix + ":events"
+	}
+	return &Redis{
+		client:  redis.NewClient(options),
+		prefix:  prefix,
+		json:    query.Get("format") == "json",
+		channel: channel,
+	}
+}
+
+// Redis stores each service under <prefix>:<name>:<id> as a hash, or as a
+// JSON payload.Value with ?format=json, and its ID in the set <prefix>:<name>.
+type Redis struct {
+	client  *redis.Client
+	prefix  string
+	json    bool
+	channel string
+}
+
+func (r *Redis) Ping() error {
+	return r.client.Ping(context.Background()).Err()
+}
+
+func (r *Redis) serviceKey(service *bridge.Service) string {
+	return r.prefix + ":" + service.Name + ":" + service.ID
+}
+
+func (r *Redis) setKey(service *bridge.Service) string {
+	return r.prefix + ":" + service.Name
+}
+
+func (r *Redis) Register(service *bridge.Service) error {
+	if err := r.write(service); err != nil {
+		log.Error("redis: failed to register service", "serviceID", service.ID, "error", err)
+		return err
+	}
+	r.publish("register", service)
+	return nil
+}
+
+// write stores the service and adds it to its set, in one transaction. Both
+// expire after the service TTL, so the set lives as long as one of its
+// services is refreshed.
+func (r *Redis) write(service *bridge.Service) error {
+	ctx := context.Background()
+	key := r.serviceKey(service)
+	ttl := time.Duration(service.TTL) * time.Second
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if r.json {
+			data, err := json.Marshal(payload.New(service))
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, key, data, ttl)
+		} else {
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, encodeHash(service))
+			if ttl > 0 {
+				pipe.Expire(ctx, key, ttl)
+			}
+		}
+		pipe.SAdd(ctx, r.setKey(service), service.ID)
+		if ttl > 0 {
+			pipe.Expire(ctx, r.setKey(service), ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) Deregister(service *bridge.Service) error {
+	ctx := context.Background()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.serviceKey(service))
+		pipe.SRem(ctx, r.setKey(service), service.ID)
+		return nil
+	})
+	if err != nil {
+		log.Error("redis: failed to deregister service", "serviceID", service.ID, "error", err)
+		return err
+	}
+	r.publish("deregister", service)
+	return nil
+}
+
+func (r *Redis) Refresh(service *bridge.Service) error {
+	if service.TTL <= 0 {
+		return nil
+	}
+	return r.write(service)
+}
+
+func (r *Redis) publish(name string, service *bridge.Service) {
+	data, err := json.Marshal(&payload.Event{Event: name, Value: payload.New(service)})
+	if err != nil {
+		return
+	}
+	if err := r.client.Publish(context.Background(), r.channel, data).Err(); err != nil {
+		log.Error("redis: failed to publish event", "channel", r.channel, "error", err)
+	}
+}
+
+// Services scans the keys under the prefix. Sets and other keys not holding
+// a service are skipped.
+func (r *Redis) Services() ([]*bridge.Service, error) {
+	ctx := context.Background()
+	keyType := "hash"
+	if r.json {
+		keyType = "string"
+	}
+	services := make([]*bridge.Service, 0)
+	iter := r.client.ScanType(ctx, 0, r.prefix+":*", scanCount, keyType).Iterator()
+	for iter.Next(ctx) {
+		service, err := r.read(ctx, iter.Val())
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				log.Error("redis: failed to decode service", "key", iter.Val(), "error", err)
+			}
+			continue
+		}
+		services = append(services, service)
+	}
+	if err := iter.Err(); err != nil {
+		return []*bridge.Service{}, err
+	}
+	return services, nil
+}
+
+func (r *Redis) read(ctx context.Context, key string) (*bridge.Service, error) {
+	if r.json {
+		data, err := r.client.Get(ctx, key).Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return payload.Decode(data)
+	}
+	fields, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeHash(fields)
+}
+
+// encodeHash stores attributes as attr.<name> fields and tags comma-separated.
+func encodeHash(service *bridge.Service) map[string]string {
+	fields := map[string]string{
+		"id":   service.ID,
+		"name": service.Name,
+		"ip":   service.IP,
+		"port": strconv.Itoa(service.Port),
+		"tags": strings.Join(service.Tags, ","),
+	}
+	for k, v := range service.Attrs {
+		fields["attr."+k] = v
+	}
+	return fields
+}
+
+func decodeHash(fields map[string]string) (*bridge.Service, error) {
+	if fields["id"] == "" || fields["name"] == "" {
+		return nil, errors.New("not a service")
+	}
+	port, err := strconv.Atoi(fields["port"])
+	if err != nil {
+		return nil, err
+	}
+	service := &bridge.Service{
+		ID:    fields["id"],
+		Name:  fields["name"],
+		IP:    fields["ip"],
+		Port:  port,
+		Attrs: make(map[string]string),
+	}
+	if fields["tags"] != "" {
+		service.Tags = strings.Split(fields["tags"], ",")
+	}
+	for k, v := range fields {
+		if attr, ok := strings.CutPrefix(k, "attr."); ok {
+			service.Attrs[attr] = v
+		}
+	}
+	return service, nil
+}
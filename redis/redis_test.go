@@ -0,0 +1,167 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedis(t *testing.T, query string) (*miniredis.Miniredis, *Redis) {
+	m := miniredis.RunT(t)
+	uri, err := url.Parse("redis://" + m.Addr() + "/0?" + query)
+	assert.NoError(t, err)
+	r := new(Factory).New(uri).(*Redis)
+	assert.NoError(t, r.Ping())
+	return m, r
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		key   string
+		ttl   int
+		// stored checks the value written for the service
+		stored func(t *testing.T, m *miniredis.Miniredis, key string)
+	}{
+		{
+			name: "hash with ttl",
+			key:  "services:web:host:web:80",
+			ttl:  30,
+			stored: func(t *testing.T, m *miniredis.Miniredis, key string) {
+				assert.Equal(t, "10.0.0.5", m.HGet(key, "ip"))
+				assert.Equal(t, "8080", m.HGet(key, "port"))
+				assert.Equal(t, "prod,eu", m.HGet(key, "tags"))
+				assert.Equal(t, "1.2", m.HGet(key, "attr.version"))
+			},
+		},
+		{
+			name:  "json without ttl",
+			query: "format=json&prefix=discovery",
+			key:   "discovery:web:host:web:80",
+			stored: func(t *testing.T, m *miniredis.Miniredis, key string) {
+				data, err := m.Get(key)
+				assert.NoError(t, err)
+				assert.JSONEq(t, `{"id":"host:web:80","name":"web","ip":"10.0.0.5","port":8080,"tags":["prod","eu"],"attrs":{"version":"1.2"}}`, data)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		m, r := newTestRedis(t, tt.query)
+		service := &bridge.Service{
+			ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: tt.ttl,
+			Tags:  []string{"prod", "eu"},
+			Attrs: map[string]string{"version": "1.2"},
+		}
+		assert.NoError(t, r.Register(service), tt.name)
+
+		tt.stored(t, m, tt.key)
+		assert.Equal(t, time.Duration(tt.ttl)*time.Second, m.TTL(tt.key), tt.name)
+		set := strings.TrimSuffix(tt.key, ":host:web:80")
+		members, err := m.Members(set)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"host:web:80"}, members, tt.name)
+		if tt.ttl > 0 {
+			m.FastForward(time.Duration(tt.ttl+1) * time.Second)
+			assert.False(t, m.Exists(tt.key), tt.name)
+			assert.False(t, m.Exists(set), tt.name)
+		}
+	}
+}
+
+func TestDeregisterPublishes(t *testing.T) {
+	m, r := newTestRedis(t, "channel=events")
+	sub := r.client.Subscribe(context.Background(), "events")
+	defer sub.Close()
+	_, err := sub.Receive(context.Background())
+	assert.NoError(t, err)
+
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080}
+	assert.NoError(t, r.Register(service))
+	assert.NoError(t, r.Deregister(service))
+	assert.False(t, m.Exists("services:web:host:web:80"))
+	members, _ := m.Members("services:web")
+	assert.Empty(t, members)
+
+	for _, expected := range []string{"register", "deregister"} {
+		msg := <-sub.Channel()
+		e := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal([]byte(msg.Payload), &e))
+		assert.Equal(t, expected, e["event"])
+		assert.Equal(t, "host:web:80", e["id"])
+	}
+}
+
+func TestServices(t *testing.T) {
+	tests := []struct {
+		format   string
+		services []*bridge.Service
+	}{
+		{format: "", services: []*bridge.Service{
+			{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Tags: []string{"prod", "eu"}, Attrs: map[string]string{"version": "1.2"}},
+		}},
+		{format: "json", services: []*bridge.Service{
+			{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, Tags: []string{"prod", "eu"}, Attrs: map[string]string{"version": "1.2"}},
+		}},
+		{format: "", services: []*bridge.Service{
+			{ID: "host:api:80", Name: "api", IP: "10.0.0.6", Port: 9090, Attrs: map[string]string{}},
+		}},
+	}
+
+	for _, tt := range tests {
+		m, r := newTestRedis(t, "format="+tt.format)
+		for _, service := range tt.services {
+			assert.NoError(t, r.Register(service))
+		}
+		m.Set("services:unrelated", "x")
+		m.HSet("services:broken", "id", "broken")
+
+		services, err := r.Services()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, tt.services, services, tt.format)
+	}
+}
+
+func TestDecodeHash(t *testing.T) {
+	tests := []struct {
+		fields   map[string]string
+		expected *bridge.Service
+	}{
+		{
+			fields:   map[string]string{"id": "a", "name": "web", "ip": "10.0.0.5", "port": "80", "tags": "x,y", "attr.k": "v"},
+			expected: &bridge.Service{ID: "a", Name: "web", IP: "10.0.0.5", Port: 80, Tags: []string{"x", "y"}, Attrs: map[string]string{"k": "v"}},
+		},
+		{fields: map[string]string{"id": "a", "name": "web", "port": "http"}},
+		{fields: map[string]string{"name": "web", "port": "80"}},
+		{fields: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		service, err := decodeHash(tt.fields)
+		if tt.expected == nil {
+			assert.Error(t, err, tt.fields)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, service)
+		assert.Equal(t, tt.fields, encodeHash(service))
+	}
+}
+
+func TestRefreshExtendsTTL(t *testing.T) {
+	m, r := newTestRedis(t, "")
+	service := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080, TTL: 30}
+	assert.NoError(t, r.Register(service))
+	m.FastForward(20 * time.Second)
+	assert.NoError(t, r.Refresh(service))
+	assert.Equal(t, 30*time.Second, m.TTL("services:web:host:web:80"))
+	assert.Equal(t, 30*time.Second, m.TTL("services:web"))
+}
@@ -185,7 +185,12 @@ func main() {
 					log.Debug("Handle container event die", "container", event.Actor.ID)
 					go b.RemoveOnExit(event.Actor.ID)
 				default:
-					log.Debug("Ignore container event", "action", event.Action, "actor", event.Actor)
+					if strings.HasPrefix(string(event.Action), string(events.ActionHealthStatus)) {
+						log.Debug("Handle container event health_status", "container", event.Actor.ID, "action", event.Action)
+						go b.UpdateHealth(event.Actor.ID)
+					} else {
+						log.Debug("Ignore container event", "action", event.Action, "actor", event.Actor)
+					}
 				}
 			} else {
 				log.Debug("Ignore event", "type", event.Type, "action", event.Action, "container", event.Actor.ID)
@@ -1,15 +1,20 @@
 package skydns2
 
 import (
+	"encoding/json"
 	"github.com/quangnguyen/registrator/bridge"
 	log "log/slog"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/coreos/go-etcd/etcd"
 )
 
+// keyNotFound is the etcd v2 error code returned when the domain has no entries yet.
+const keyNotFound = 100
+
 func init() {
 	bridge.Register(new(Factory), "skydns2")
 }
@@ -26,7 +31,7 @@ func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
 		log.Error("skydns2: dns domain required e.g.: skydns2://<host>/<domain>")
 	}
 
-	return &Skydns2{client: etcd.NewClient(urls), path: domainPath(uri.Path[1:])}
+	return &Skydns2{client: etcd.NewClient(urls), path: DomainPath(uri.Path[1:])}
 }
 
 type Skydns2 struct {
@@ -34,6 +39,16 @@ type Skydns2 struct {
 	path   string
 }
 
+// record is the JSON value SkyDNS 2 expects for a service entry.
+type record struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+	Weight      int    `json:"weight,omitempty"`
+	Text        string `json:"text,omitempty"`
+	TargetStrip int    `json:"targetstrip,omitempty"`
+}
+
 func (r *Skydns2) Ping() error {
 	rr := etcd.NewRawRequest("GET", "version", nil, nil)
 	_, err := r.client.SendRequest(rr)
@@ -44,9 +59,12 @@ func (r *Skydns2) Ping() error {
 }
 
 func (r *Skydns2) Register(service *bridge.Service) error {
-	port := strconv.Itoa(service.Port)
-	record := `{"host":"` + service.IP + `","port":` + port + `}`
-	_, err := r.client.Set(r.servicePath(service), record, uint64(service.TTL))
+	value, err := json.Marshal(newRecord(service))
+	if err != nil {
+		log.Error("skydns2: failed to json encode service record", "error", err)
+		return err
+	}
+	_, err = r.client.Set(r.servicePath(service), string(value), uint64(service.TTL))
 	if err != nil {
 		log.Error("skydns2: failed to register service", "error", err)
 	}
@@ -66,14 +84,86 @@ func (r *Skydns2) Refresh(service *bridge.Service) error {
 }
 
 func (r *Skydns2) Services() ([]*bridge.Service, error) {
-	return []*bridge.Service{}, nil
+	resp, err := r.client.Get(r.path, false, true)
+	if err != nil {
+		if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == keyNotFound {
+			return []*bridge.Service{}, nil
+		}
+		return []*bridge.Service{}, err
+	}
+
+	services := make([]*bridge.Service, 0)
+	for _, nameNode := range resp.Node.Nodes {
+		if !nameNode.Dir {
+			continue
+		}
+		name := path.Base(nameNode.Key)
+		for _, idNode := range nameNode.Nodes {
+			if idNode.Dir {
+				continue
+			}
+			service, err := decodeService(name, path.Base(idNode.Key), idNode.Value)
+			if err != nil {
+				log.Error("skydns2: failed to decode service record", "key", idNode.Key, "error", err)
+				continue
+			}
+			services = append(services, service)
+		}
+	}
+	return services, nil
 }
 
 func (r *Skydns2) servicePath(service *bridge.Service) string {
 	return r.path + "/" + service.Name + "/" + service.ID
 }
 
-func domainPath(domain string) string {
+func newRecord(service *bridge.Service) *record {
+	rec := &record{
+		Host: service.IP,
+		Port: service.Port,
+		Text: service.Attrs["text"],
+	}
+	if priority, err := strconv.Atoi(service.Attrs["priority"]); err == nil {
+		rec.Priority = priority
+	}
+	if weight, err := strconv.Atoi(service.Attrs["weight"]); err == nil {
+		rec.Weight = weight
+	}
+	if targetStrip, err := strconv.Atoi(service.Attrs["targetstrip"]); err == nil {
+		rec.TargetStrip = targetStrip
+	}
+	return rec
+}
+
+func decodeService(name, id, value string) (*bridge.Service, error) {
+	rec := new(record)
+	if err := json.Unmarshal([]byte(value), rec); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string]string)
+	if rec.Priority != 0 {
+		attrs["priority"] = strconv.Itoa(rec.Priority)
+	}
+	if rec.Weight != 0 {
+		attrs["weight"] = strconv.Itoa(rec.Weight)
+	}
+	if rec.Text != "" {
+		attrs["text"] = rec.Text
+	}
+	if rec.TargetStrip != 0 {
+		attrs["targetstrip"] = strconv.Itoa(rec.TargetStrip)
+	}
+	return &bridge.Service{
+		ID:    id,
+		Name:  name,
+		IP:    rec.Host,
+		Port:  rec.Port,
+		Attrs: attrs,
+	}, nil
+}
+
+// DomainPath converts a DNS domain into the reversed /skydns key prefix.
+func DomainPath(domain string) string {
 	components := strings.Split(domain, ".")
 	for i, j := 0, len(components)-1; i < j; i, j = i+1, j-1 {
 		components[i], components[j] = components[j], components[i]
@@ -0,0 +1,42 @@
+package skydns2
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainPath(t *testing.T) {
+	assert.Equal(t, "/skydns/local/cluster", DomainPath("cluster.local"))
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	service := &bridge.Service{
+		ID:   "host:redis:6379",
+		Name: "redis",
+		IP:   "10.0.0.5",
+		Port: 6379,
+		Attrs: map[string]string{
+			"priority":    "10",
+			"weight":      "50",
+			"text":        `say "hi"`,
+			"targetstrip": "1",
+		},
+	}
+
+	value, err := json.Marshal(newRecord(service))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"host":"10.0.0.5","port":6379,"priority":10,"weight":50,"text":"say \"hi\"","targetstrip":1}`, string(value))
+
+	decoded, err := decodeService(service.Name, service.ID, string(value))
+	assert.NoError(t, err)
+	assert.Equal(t, service, decoded)
+}
+
+func TestRecordOmitsUnsetAttrs(t *testing.T) {
+	value, err := json.Marshal(newRecord(&bridge.Service{IP: "10.0.0.5", Port: 80, Attrs: map[string]string{"weight": "heavy"}}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"host":"10.0.0.5","port":80}`, string(value))
+}
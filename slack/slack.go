@@ -0,0 +1,107 @@
+package slack
+
+import (
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/notify"
+)
+
+// maxBlocks is the number of blocks Slack accepts in one message.
+const maxBlocks = 50
+
+func init() {
+	bridge.Register(new(Factory), "slack")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	s := &Slack{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		webhook: notify.WebhookURL(uri),
+	}
+	s.Notifier = notify.New(notify.ParseOptions("slack", uri.Query(), "SLACK", escape), s.flush)
+	go s.Run()
+
+	return s
+}
+
+// Slack posts notifications to a Slack incoming webhook as Block Kit messages.
+type Slack struct {
+	*notify.Notifier
+	client  *http.Client
+	webhook string
+}
+
+// Ping succeeds without contacting Slack, incoming webhooks only accept
+// messages.
+func (s *Slack) Ping() error {
+	return nil
+}
+
+// message is the body of an incoming webhook request. Text is the fallback
+// shown in notifications.
+type message struct {
+	Text   string   `json:"text"`
+	Blocks []*block `json:"blocks"`
+}
+
+type block struct {
+	Type string `json:"type"`
+	Text *text  `json:"text,omitempty"`
+}
+
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func section(s string) *block {
+	return &block{Type: "section", Text: &text{Type: "mrkdwn", Text: s}}
+}
+
+func (s *Slack) flush(batch []*notify.Notification, dropped int64) {
+	for _, msg := range messages(batch, dropped) {
+		if err := notify.PostJSON(s.client, s.webhook, msg); err != nil {
+			log.Error("Could not send message to Slack", "error", err)
+		}
+	}
+}
+
+// messages builds one section per notification, split in messages of at
+// most maxBlocks blocks.
+func messages(batch []*notify.Notification, dropped int64) []*message {
+	lines := make([]string, 0, len(batch)+1)
+	for _, n := range batch {
+		emoji := ":large_green_circle:"
+		if !n.Online {
+			emoji = ":red_circle:"
+		}
+		lines = append(lines, emoji+" "+n.Text)
+	}
+	if dropped > 0 {
+		lines = append(lines, ":warning: "+notify.DroppedText(dropped))
+	}
+
+	out := make([]*message, 0)
+	for len(lines) > 0 {
+		n := min(len(lines), maxBlocks)
+		msg := &message{Text: strings.Join(lines[:n], "\n")}
+		for _, line := range lines[:n] {
+			msg.Blocks = append(msg.Blocks, section(line))
+		}
+		out = append(out, msg)
+		lines = lines[n:]
+	}
+	return out
+}
+
+// escape quotes the characters Slack treats as control sequences.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
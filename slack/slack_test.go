@@ -0,0 +1,51 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushPostsBlocks(t *testing.T) {
+	received := make([]*message, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := new(message)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(msg))
+		received = append(received, msg)
+	}))
+	defer server.Close()
+
+	s := &Slack{client: server.Client(), webhook: server.URL}
+	s.flush([]*notify.Notification{
+		{Online: true, Service: &bridge.Service{Name: "web"}, Text: "web is up"},
+		{Online: false, Service: &bridge.Service{Name: "db"}, Text: "db is down"},
+	}, 2)
+
+	assert.Len(t, received, 1)
+	assert.Equal(t, ":large_green_circle: web is up\n:red_circle: db is down\n:warning: 2 notifications dropped", received[0].Text)
+	assert.Equal(t, []*block{
+		section(":large_green_circle: web is up"),
+		section(":red_circle: db is down"),
+		section(":warning: 2 notifications dropped"),
+	}, received[0].Blocks)
+}
+
+func TestMessagesSplitAtBlockLimit(t *testing.T) {
+	batch := make([]*notify.Notification, 0)
+	for i := 0; i < maxBlocks+1; i++ {
+		batch = append(batch, &notify.Notification{Online: true, Text: "up"})
+	}
+	msgs := messages(batch, 0)
+	assert.Len(t, msgs, 2)
+	assert.Len(t, msgs[0].Blocks, maxBlocks)
+	assert.Len(t, msgs[1].Blocks, 1)
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, "a &lt;b&gt; &amp; c", escape("a <b> & c"))
+}
@@ -0,0 +1,106 @@
+package teams
+
+import (
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/notify"
+)
+
+func init() {
+	bridge.Register(new(Factory), "teams")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	t := &Teams{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		webhook: notify.WebhookURL(uri),
+	}
+	t.Notifier = notify.New(notify.ParseOptions("teams", uri.Query(), "TEAMS", escape), t.flush)
+	go t.Run()
+
+	return t
+}
+
+// Teams posts notifications to a Microsoft Teams incoming webhook or
+// workflow as Adaptive Cards.
+type Teams struct {
+	*notify.Notifier
+	client  *http.Client
+	webhook string
+}
+
+// Ping succeeds without contacting Teams, incoming webhooks only accept
+// messages.
+func (t *Teams) Ping() error {
+	return nil
+}
+
+type message struct {
+	Type        string        `json:"type"`
+	Attachments []*attachment `json:"attachments"`
+}
+
+type attachment struct {
+	ContentType string `json:"contentType"`
+	Content     *card  `json:"content"`
+}
+
+type card struct {
+	Schema  string       `json:"$schema"`
+	Type    string       `json:"type"`
+	Version string       `json:"version"`
+	Body    []*textBlock `json:"body"`
+}
+
+type textBlock struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Color string `json:"color,omitempty"`
+	Wrap  bool   `json:"wrap"`
+}
+
+func (t *Teams) flush(batch []*notify.Notification, dropped int64) {
+	if err := notify.PostJSON(t.client, t.webhook, newMessage(batch, dropped)); err != nil {
+		log.Error("Could not send message to Teams", "error", err)
+	}
+}
+
+// newMessage builds a card with a text block per notification, green for
+// online and red for offline services.
+func newMessage(batch []*notify.Notification, dropped int64) *message {
+	c := &card{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+	}
+	for _, n := range batch {
+		color := "Good"
+		if !n.Online {
+			color = "Attention"
+		}
+		c.Body = append(c.Body, &textBlock{Type: "TextBlock", Text: n.Text, Color: color, Wrap: true})
+	}
+	if dropped > 0 {
+		c.Body = append(c.Body, &textBlock{Type: "TextBlock", Text: notify.DroppedText(dropped), Color: "Warning", Wrap: true})
+	}
+	return &message{
+		Type: "message",
+		Attachments: []*attachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content:     c,
+		}},
+	}
+}
+
+// escape keeps values from being read as the Markdown subset text blocks
+// support.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`).Replace(s)
+}
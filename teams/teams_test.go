@@ -0,0 +1,41 @@
+package teams
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushPostsAdaptiveCard(t *testing.T) {
+	var received *message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = new(message)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(received))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	tm := &Teams{client: server.Client(), webhook: server.URL}
+	tm.flush([]*notify.Notification{
+		{Online: true, Service: &bridge.Service{Name: "web"}, Text: "web is up"},
+		{Online: false, Service: &bridge.Service{Name: "db"}, Text: "db is down"},
+	}, 0)
+
+	assert.NotNil(t, received)
+	assert.Equal(t, "message", received.Type)
+	assert.Len(t, received.Attachments, 1)
+	assert.Equal(t, "application/vnd.microsoft.card.adaptive", received.Attachments[0].ContentType)
+	assert.Equal(t, []*textBlock{
+		{Type: "TextBlock", Text: "web is up", Color: "Good", Wrap: true},
+		{Type: "TextBlock", Text: "db is down", Color: "Attention", Wrap: true},
+	}, received.Attachments[0].Content.Body)
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `api\_1 \*prod\*`, escape("api_1 *prod*"))
+}
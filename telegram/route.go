@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"errors"
+	log "log/slog"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+// destination is a chat, optionally a forum topic in it, that a batch of
+// messages is sent to.
+type destination struct {
+	chatID int64
+	topic  int
+	silent bool
+}
+
+// matcher selects services by a glob on their name or on one of their tags,
+// written as name:<pattern> or tag:<pattern>.
+type matcher struct {
+	kind    string
+	pattern string
+}
+
+func parseMatcher(s string) (matcher, error) {
+	kind, pattern, found := strings.Cut(s, ":")
+	if !found || (kind != "name" && kind != "tag") || pattern == "" {
+		return matcher{}, errors.New("invalid matcher '" + s + "', expected name:<pattern> or tag:<pattern>")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return matcher{}, err
+	}
+	return matcher{kind: kind, pattern: pattern}, nil
+}
+
+func (m matcher) matches(service *bridge.Service) bool {
+	if m.kind == "name" {
+		ok, _ := path.Match(m.pattern, service.Name)
+		return ok
+	}
+	for _, tag := range service.Tags {
+		if ok, _ := path.Match(m.pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// route sends the services it matches to its chat instead of the default ones.
+type route struct {
+	matcher
+	chat destination
+}
+
+// parseRoute parses <matcher>@<chat id>[:<topic id>], e.g. tag:db@-1001234567890:42.
+func parseRoute(s string) (route, error) {
+	m, target, found := strings.Cut(s, "@")
+	if !found {
+		return route{}, errors.New("invalid route '" + s + "', expected <matcher>@<chat id>[:<topic id>]")
+	}
+	match, err := parseMatcher(m)
+	if err != nil {
+		return route{}, err
+	}
+	chat, err := parseChat(target)
+	if err != nil {
+		return route{}, err
+	}
+	return route{matcher: match, chat: chat}, nil
+}
+
+// parseChat parses <chat id>[:<topic id>].
+func parseChat(s string) (destination, error) {
+	chat, topic, hasTopic := strings.Cut(s, ":")
+	chatID, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		return destination{}, errors.New("invalid chat id '" + chat + "'")
+	}
+	d := destination{chatID: chatID}
+	if hasTopic {
+		if d.topic, err = strconv.Atoi(topic); err != nil {
+			return destination{}, errors.New("invalid topic id '" + topic + "'")
+		}
+	}
+	return d, nil
+}
+
+// parseChats parses the comma separated chats of the URI host and the chat
+// query parameters, skipping empty entries such as an empty host.
+func parseChats(host string, chats []string) []destination {
+	destinations := make([]destination, 0)
+	for _, chat := range append(strings.Split(host, ","), chats...) {
+		if chat == "" {
+			continue
+		}
+		d, err := parseChat(chat)
+		if err != nil {
+			log.Error("Invalid chat ID", "error", err)
+			continue
+		}
+		destinations = append(destinations, d)
+	}
+	return destinations
+}
+
+// router picks the chats a service's notifications go to.
+type router struct {
+	chats  []destination
+	routes []route
+	silent []matcher
+}
+
+// destinations returns the chats of every route matching the service, or the
+// default chats when none does. Notifications for services matching a silent
+// matcher, or with SERVICE_TELEGRAM_SILENT set, are sent without sound.
+func (r *router) destinations(service *bridge.Service) []destination {
+	silent, _ := strconv.ParseBool(service.Attrs["telegram_silent"])
+	for _, m := range r.silent {
+		if m.matches(service) {
+			silent = true
+			break
+		}
+	}
+
+	targets := make([]destination, 0)
+	for _, rt := range r.routes {
+		if rt.matches(service) {
+			targets = append(targets, rt.chat)
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, r.chats...)
+	}
+
+	seen := make(map[destination]bool)
+	out := make([]destination, 0, len(targets))
+	for _, d := range targets {
+		d.silent = silent
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoute(t *testing.T) {
+	r, err := parseRoute("tag:db@-1001234567890:42")
+	assert.NoError(t, err)
+	assert.Equal(t, route{
+		matcher: matcher{kind: "tag", pattern: "db"},
+		chat:    destination{chatID: -1001234567890, topic: 42},
+	}, r)
+
+	for _, invalid := range []string{"tag:db", "image:db@1", "name:db@abc", "name:db@1:x", "name:[@1"} {
+		_, err := parseRoute(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRouterDestinations(t *testing.T) {
+	defaults := destination{chatID: 1}
+	db := destination{chatID: 2, topic: 7}
+	payments := destination{chatID: 3}
+	r := &router{
+		chats: []destination{defaults},
+		routes: []route{
+			{matcher: matcher{kind: "tag", pattern: "db"}, chat: db},
+			{matcher: matcher{kind: "name", pattern: "payments-*"}, chat: payments},
+			{matcher: matcher{kind: "tag", pattern: "primary"}, chat: db},
+		},
+		silent: []matcher{{kind: "tag", pattern: "batch"}},
+	}
+
+	assert.Equal(t, []destination{defaults}, r.destinations(&bridge.Service{Name: "web"}))
+	assert.Equal(t, []destination{db}, r.destinations(&bridge.Service{Name: "pg", Tags: []string{"db", "primary"}}))
+	assert.Equal(t, []destination{db, payments}, r.destinations(&bridge.Service{Name: "payments-db", Tags: []string{"db"}}))
+	assert.Equal(t, []destination{{chatID: 1, silent: true}}, r.destinations(&bridge.Service{Name: "report", Tags: []string{"batch"}}))
+	assert.Equal(t, []destination{{chatID: 1, silent: true}},
+		r.destinations(&bridge.Service{Name: "web", Attrs: map[string]string{"telegram_silent": "true"}}))
+}
+
+func TestParseChats(t *testing.T) {
+	tests := []struct {
+		host     string
+		chats    []string
+		expected []destination
+	}{
+		{host: "", chats: nil, expected: []destination{}},
+		{host: "", chats: []string{"1"}, expected: []destination{{chatID: 1}}},
+		{host: "1,,2:5", chats: []string{"", "3"}, expected: []destination{{chatID: 1}, {chatID: 2, topic: 5}, {chatID: 3}}},
+		{host: "abc", chats: []string{"4"}, expected: []destination{{chatID: 4}}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, parseChats(tt.host, tt.chats), tt.host)
+	}
+}
@@ -1,19 +1,17 @@
 package telegram
 
 import (
+	"errors"
 	log "log/slog"
 	"net/url"
 	"os"
-	"strconv"
-	"sync"
 	"time"
 
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/notify"
 )
 
-var registeredServices sync.Map
-
 func init() {
 	bridge.Register(new(Factory), "telegram")
 }
@@ -27,21 +25,46 @@ func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
 		log.Error("Failed to create Telegram bot", "error", err)
 	}
 
-	chatID, err := strconv.ParseInt(uri.Host, 10, 64)
-	if err != nil {
-		log.Error("Invalid chat ID", "error", err)
+	query := uri.Query()
+	router := &router{chats: parseChats(uri.Host, query["chat"])}
+	for _, r := range query["route"] {
+		rt, err := parseRoute(r)
+		if err != nil {
+			log.Error("Invalid Telegram route", "error", err)
+			continue
+		}
+		router.routes = append(router.routes, rt)
+	}
+	for _, s := range query["silent"] {
+		m, err := parseMatcher(s)
+		if err != nil {
+			log.Error("Invalid Telegram silent matcher", "error", err)
+			continue
+		}
+		router.silent = append(router.silent, m)
 	}
 
-	t := &Telegram{bot: bot, chatID: chatID, messageQueue: make(chan string, 100)}
-	go t.processMessageQueue()
+	parseMode := query.Get("parse_mode")
+	if parseMode == "" {
+		parseMode = os.Getenv("TELEGRAM_PARSE_MODE")
+	}
+
+	t := &Telegram{
+		bot:       bot,
+		router:    router,
+		parseMode: parseMode,
+	}
+	t.Notifier = notify.New(notify.ParseOptions("telegram", query, "TELEGRAM", t.escape), t.flush)
+	go t.Run()
 
 	return t
 }
 
 type Telegram struct {
-	bot          *telegram.BotAPI
-	chatID       int64
-	messageQueue chan string
+	*notify.Notifier
+	bot       *telegram.BotAPI
+	router    *router
+	parseMode string
 }
 
 func (t *Telegram) Ping() error {
@@ -54,54 +77,33 @@ func (t *Telegram) Ping() error {
 	return nil
 }
 
-func (t *Telegram) Register(service *bridge.Service) error {
-	message := "ONLINE: Service " + service.Name + " with ip " + service.IP + " goes online"
-	t.messageQueue <- message
-	registeredServices.Store(service.Name, service.ID)
-	return nil
-}
-
-func (t *Telegram) Deregister(service *bridge.Service) error {
-	if _, serviceID := registeredServices.LoadAndDelete(service.Name); serviceID {
-		message := "OFFLINE: Service " + service.Name + " with ip " + service.IP + " goes offline"
-		t.messageQueue <- message
-		return nil
+// flush sends each notification to the chats its service is routed to, and
+// the number of dropped notifications to the default chats.
+func (t *Telegram) flush(batch []*notify.Notification, dropped int64) {
+	batches := make(map[destination][]string)
+	for _, n := range batch {
+		for _, d := range t.router.destinations(n.Service) {
+			batches[d] = append(batches[d], n.Text)
+		}
+	}
+	if dropped > 0 {
+		for _, d := range t.router.chats {
+			batches[d] = append(batches[d], t.escape(notify.DroppedText(dropped)))
+		}
+	}
+	for d, messages := range batches {
+		t.sendMessageBatch(d, messages)
 	}
-	return nil
-}
-
-func (t *Telegram) Refresh(_ *bridge.Service) error {
-	return nil
-}
-
-func (t *Telegram) Services() ([]*bridge.Service, error) {
-	var services []*bridge.Service
-	registeredServices.Range(func(serviceName, serviceID interface{}) bool {
-		service := &bridge.Service{ID: serviceName.(string)}
-		services = append(services, service)
-		return true
-	})
-	return services, nil
 }
 
-func (t *Telegram) processMessageQueue() {
-	var messages []string
-	timer := time.NewTimer(time.Second * 5)
-	for {
-		select {
-		case msg := <-t.messageQueue:
-			messages = append(messages, msg)
-		case <-timer.C:
-			if len(messages) > 0 {
-				t.sendMessageBatch(messages)
-				messages = nil
-			}
-			timer.Reset(time.Second * 1)
-		}
+func (t *Telegram) escape(text string) string {
+	if t.parseMode == "" {
+		return text
 	}
+	return telegram.EscapeText(t.parseMode, text)
 }
 
-func (t *Telegram) sendMessageBatch(messages []string) {
+func (t *Telegram) sendMessageBatch(d destination, messages []string) {
 	if len(messages) == 0 {
 		return
 	}
@@ -109,13 +111,31 @@ func (t *Telegram) sendMessageBatch(messages []string) {
 	for _, msg := range messages {
 		messageText += msg + "\n"
 	}
-	t.sendMessage(messageText)
+	t.sendMessage(d, messageText)
 }
 
-func (t *Telegram) sendMessage(text string) {
-	msg := telegram.NewMessage(t.chatID, text)
-	_, err := t.bot.Send(msg)
-	if err != nil {
-		log.Error("Could not send message to Telegram", "error", err)
+// sendMessage sends a message, waiting as long as Telegram asks when it
+// answers 429 Too Many Requests. The request is built by hand since the bot
+// library does not know about forum topics.
+func (t *Telegram) sendMessage(d destination, text string) {
+	params := telegram.Params{}
+	params.AddNonZero64("chat_id", d.chatID)
+	params.AddNonZero("message_thread_id", d.topic)
+	params.AddNonEmpty("text", text)
+	params.AddNonEmpty("parse_mode", t.parseMode)
+	params.AddBool("disable_notification", d.silent)
+	for attempt := 1; ; attempt++ {
+		_, err := t.bot.MakeRequest("sendMessage", params)
+		if err == nil {
+			return
+		}
+		var tgErr *telegram.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 && attempt < notify.MaxSendAttempts {
+			log.Info("Telegram rate limit reached, retrying", "retryAfter", tgErr.RetryAfter)
+			time.Sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+			continue
+		}
+		log.Error("Could not send message to Telegram", "chatID", d.chatID, "error", err)
+		return
 	}
 }
@@ -0,0 +1,13 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, "a<b>", (&Telegram{}).escape("a<b>"))
+	assert.Equal(t, "a&lt;b&gt;", (&Telegram{parseMode: "HTML"}).escape("a<b>"))
+	assert.Equal(t, `api\-1`, (&Telegram{parseMode: "MarkdownV2"}).escape("api-1"))
+}
@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	log "log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/quangnguyen/registrator/notify"
+)
+
+const (
+	defaultRetries       = 3
+	defaultBackoff       = time.Second
+	defaultBatchInterval = time.Second
+	defaultTimeout       = 10 * time.Second
+
+	// SignatureHeader carries the hex HMAC-SHA256 of the request body, keyed
+	// with the webhook secret, as sha256=<hex>.
+	SignatureHeader = "X-Registrator-Signature"
+)
+
+// settings are read from the URI query and not forwarded to the endpoint.
+var settings = []string{"secret", "retries", "backoff", "batch_interval", "queue_size", "timeout"}
+
+func init() {
+	f := new(Factory)
+	bridge.Register(f, "webhook")
+	bridge.Register(f, "webhooks")
+}
+
+type Factory struct{}
+
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	query := uri.Query()
+	endpoint := &url.URL{Scheme: "http", Host: uri.Host, Path: uri.Path}
+	if uri.Scheme == "webhooks" {
+		endpoint.Scheme = "https"
+	}
+	forwarded := url.Values{}
+	for k, v := range query {
+		forwarded[k] = v
+	}
+	for _, k := range settings {
+		forwarded.Del(k)
+	}
+	endpoint.RawQuery = forwarded.Encode()
+
+	retries, err := strconv.Atoi(notify.Setting(query, "retries", "WEBHOOK_RETRIES", strconv.Itoa(defaultRetries)))
+	if err != nil || retries < 0 {
+		log.Error("Invalid webhook retries, using default", "error", err)
+		retries = defaultRetries
+	}
+	queueSize, err := strconv.Atoi(notify.Setting(query, "queue_size", "WEBHOOK_QUEUE_SIZE", strconv.Itoa(notify.DefaultQueueSize)))
+	if err != nil || queueSize <= 0 {
+		log.Error("Invalid webhook queue size, using default", "error", err)
+		queueSize = notify.DefaultQueueSize
+	}
+	batchInterval := notify.Duration(query, "batch_interval", "WEBHOOK_BATCH_INTERVAL", defaultBatchInterval)
+	if batchInterval <= 0 {
+		log.Error("Invalid webhook batch interval, using default", "batchInterval", batchInterval)
+		batchInterval = defaultBatchInterval
+	}
+
+	w := &Webhook{
+		client:        &http.Client{Timeout: notify.Duration(query, "timeout", "WEBHOOK_TIMEOUT", defaultTimeout)},
+		endpoint:      endpoint.String(),
+		secret:        []byte(notify.Setting(query, "secret", "WEBHOOK_SECRET", "")),
+		retries:       retries,
+		backoff:       notify.Duration(query, "backoff", "WEBHOOK_BACKOFF", defaultBackoff),
+		batchInterval: batchInterval,
+		queue:         make(chan *Event, queueSize),
+	}
+	go w.processQueue()
+
+	return w
+}
+
+// Event is the envelope posted for each registration change. A request body
+// is a JSON array of events. When events were dropped because the queue was
+// full, the next body ends with a "dropped" event carrying their count and
+// no service.
+type Event struct {
+	Event     string          `json:"event"`
+	Service   *bridge.Service `json:"service,omitempty"`
+	Host      string          `json:"host"`
+	Timestamp time.Time       `json:"timestamp"`
+	Dropped   int64           `json:"dropped,omitempty"`
+}
+
+type Webhook struct {
+	client        *http.Client
+	endpoint      string
+	secret        []byte
+	retries       int
+	backoff       time.Duration
+	batchInterval time.Duration
+	queue         chan *Event
+	dropped       atomic.Int64
+
+	// services holds the registered services by ID.
+	services sync.Map
+}
+
+// Ping succeeds without contacting the endpoint, which is only expected to
+// accept POSTs.
+func (w *Webhook) Ping() error {
+	return nil
+}
+
+func (w *Webhook) Register(service *bridge.Service) error {
+	if _, loaded := w.services.LoadOrStore(service.ID, service); loaded {
+		return nil
+	}
+	w.enqueue("register", service)
+	return nil
+}
+
+func (w *Webhook) Deregister(service *bridge.Service) error {
+	if _, loaded := w.services.LoadAndDelete(service.ID); !loaded {
+		return nil
+	}
+	w.enqueue("deregister", service)
+	return nil
+}
+
+func (w *Webhook) Refresh(_ *bridge.Service) error {
+	return nil
+}
+
+func (w *Webhook) Services() ([]*bridge.Service, error) {
+	services := make([]*bridge.Service, 0)
+	w.services.Range(func(_, service interface{}) bool {
+		services = append(services, service.(*bridge.Service))
+		return true
+	})
+	return services, nil
+}
+
+// enqueue never blocks the bridge: when the queue is full the event is
+// dropped and counted.
+func (w *Webhook) enqueue(event string, service *bridge.Service) {
+	e := &Event{Event: event, Service: service, Host: bridge.Hostname, Timestamp: time.Now().UTC()}
+	select {
+	case w.queue <- e:
+	default:
+		dropped := w.dropped.Add(1)
+		log.Error("Webhook queue is full, dropping event", "serviceID", service.ID, "dropped", dropped)
+	}
+}
+
+func (w *Webhook) processQueue() {
+	batch := make([]*Event, 0)
+	ticker := time.NewTicker(w.batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-w.queue:
+			batch = append(batch, e)
+		case <-ticker.C:
+			if dropped := w.dropped.Swap(0); dropped > 0 {
+				batch = append(batch, &Event{Event: "dropped", Host: bridge.Hostname, Timestamp: time.Now().UTC(), Dropped: dropped})
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			if err := w.send(batch); err != nil {
+				log.Error("Could not post events to webhook", "endpoint", w.endpoint, "events", len(batch), "error", err)
+			}
+			batch = make([]*Event, 0)
+		}
+	}
+}
+
+// send posts a batch of events, retrying with exponential backoff on
+// connection errors, 429 and 5xx responses.
+func (w *Webhook) send(events []*Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = w.backoff
+	return backoff.RetryNotify(func() error {
+		return w.post(body)
+	}, backoff.WithMaxRetries(b, uint64(w.retries)), func(err error, next time.Duration) {
+		log.Info("Webhook request failed, retrying", "endpoint", w.endpoint, "in", next, "error", err)
+	})
+}
+
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "registrator")
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+sign(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook answered %s", resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return backoff.Permanent(err)
+}
+
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
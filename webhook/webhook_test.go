@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a webhook endpoint answering with the given status codes in
+// turn, then 200.
+type recorder struct {
+	sync.Mutex
+	statuses  []int
+	bodies    [][]byte
+	headers   []http.Header
+	requested atomic.Int32
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.Lock()
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.Unlock()
+	r.requested.Add(1)
+	w.WriteHeader(status)
+}
+
+func newTestWebhook(t *testing.T, rec *recorder, query string) *Webhook {
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	uri, err := url.Parse(strings.Replace(server.URL, "http://", "webhook://", 1) + "/hook?" + query)
+	assert.NoError(t, err)
+	return new(Factory).New(uri).(*Webhook)
+}
+
+func TestEventsAreBatchedAndSigned(t *testing.T) {
+	rec := &recorder{}
+	w := newTestWebhook(t, rec, "secret=s3cr3t&batch_interval=50ms&token=abc")
+	endpoint, err := url.Parse(w.endpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, "http", endpoint.Scheme)
+	assert.Equal(t, "/hook", endpoint.Path)
+	assert.Equal(t, "token=abc", endpoint.RawQuery)
+
+	web := &bridge.Service{ID: "host:web:80", Name: "web", IP: "10.0.0.5", Port: 8080}
+	assert.NoError(t, w.Register(web))
+	assert.NoError(t, w.Register(web))
+	assert.NoError(t, w.Deregister(web))
+
+	assert.Eventually(t, func() bool { return rec.requested.Load() == 1 }, time.Second, 10*time.Millisecond)
+	rec.Lock()
+	defer rec.Unlock()
+
+	var events []*Event
+	assert.NoError(t, json.Unmarshal(rec.bodies[0], &events))
+	assert.Len(t, events, 2)
+	assert.Equal(t, "register", events[0].Event)
+	assert.Equal(t, "deregister", events[1].Event)
+	assert.Equal(t, web.ID, events[0].Service.ID)
+	assert.Equal(t, bridge.Hostname, events[0].Host)
+	assert.False(t, events[0].Timestamp.IsZero())
+
+	assert.Equal(t, "sha256="+sign([]byte("s3cr3t"), rec.bodies[0]), rec.headers[0].Get(SignatureHeader))
+	assert.Equal(t, "application/json", rec.headers[0].Get("Content-Type"))
+}
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	rec := &recorder{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	w := newTestWebhook(t, rec, "backoff=1ms")
+
+	assert.NoError(t, w.send([]*Event{{Event: "register"}}))
+	assert.Equal(t, int32(3), rec.requested.Load())
+}
+
+func TestSendGivesUp(t *testing.T) {
+	rec := &recorder{statuses: []int{500, 500, 500}}
+	w := newTestWebhook(t, rec, "backoff=1ms&retries=2")
+	assert.Error(t, w.send([]*Event{{Event: "register"}}))
+	assert.Equal(t, int32(3), rec.requested.Load())
+
+	rec = &recorder{statuses: []int{http.StatusBadRequest}}
+	w = newTestWebhook(t, rec, "backoff=1ms")
+	assert.Error(t, w.send([]*Event{{Event: "register"}}))
+	assert.Equal(t, int32(1), rec.requested.Load())
+}
+
+func TestUnsignedWithoutSecret(t *testing.T) {
+	rec := &recorder{}
+	w := newTestWebhook(t, rec, "")
+	assert.NoError(t, w.send([]*Event{{Event: "register"}}))
+	assert.Empty(t, rec.headers[0].Get(SignatureHeader))
+}
+
+func TestDroppedEventsAreReported(t *testing.T) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	// the queue is not drained until the events are in
+	w := &Webhook{
+		client:        server.Client(),
+		endpoint:      server.URL,
+		batchInterval: 20 * time.Millisecond,
+		queue:         make(chan *Event, 1),
+	}
+	for _, id := range []string{"web", "api", "db"} {
+		assert.NoError(t, w.Register(&bridge.Service{ID: id, Name: "web"}))
+	}
+	go w.processQueue()
+
+	assert.Eventually(t, func() bool { return rec.requested.Load() >= 1 }, time.Second, 10*time.Millisecond)
+	rec.Lock()
+	defer rec.Unlock()
+
+	var events []*Event
+	assert.NoError(t, json.Unmarshal(rec.bodies[0], &events))
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "register", events[0].Event)
+		assert.Equal(t, "dropped", events[1].Event)
+		assert.Equal(t, int64(2), events[1].Dropped)
+		assert.Nil(t, events[1].Service)
+	}
+	assert.Zero(t, w.dropped.Load())
+}
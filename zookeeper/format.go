@@ -0,0 +1,239 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+)
+
+// format decides how a service is named and serialized below <base>/<service-name>.
+type format interface {
+	nodeName(service *bridge.Service) string
+	encode(service *bridge.Service, registeredAt time.Time) ([]byte, error)
+	decode(name, node string, body []byte) (*bridge.Service, error)
+}
+
+func newFormat(name string) (format, error) {
+	switch name {
+	case "", "znode":
+		return znodeFormat{}, nil
+	case "curator":
+		return curatorFormat{}, nil
+	case "serverset":
+		return serversetFormat{}, nil
+	}
+	return nil, errors.New("unknown format '" + name + "'")
+}
+
+type ZnodeBody struct {
+	ID          string
+	Name        string
+	IP          string
+	PublicPort  int
+	PrivatePort int
+	ContainerID string
+	Tags        []string
+	Attrs       map[string]string
+}
+
+// znodeFormat is registrator's own layout: <ip>:<port> = ZnodeBody.
+type znodeFormat struct{}
+
+func (znodeFormat) nodeName(service *bridge.Service) string {
+	return service.IP + ":" + strconv.Itoa(service.Port)
+}
+
+func (znodeFormat) encode(service *bridge.Service, _ time.Time) ([]byte, error) {
+	privatePort, _ := strconv.Atoi(service.Origin.ExposedPort)
+	return json.Marshal(&ZnodeBody{
+		ID:          service.ID,
+		Name:        service.Name,
+		IP:          service.IP,
+		PublicPort:  service.Port,
+		PrivatePort: privatePort,
+		ContainerID: service.Origin.ContainerHostname,
+		Tags:        service.Tags,
+		Attrs:       service.Attrs,
+	})
+}
+
+func (znodeFormat) decode(_, _ string, body []byte) (*bridge.Service, error) {
+	zbody := new(ZnodeBody)
+	if err := json.Unmarshal(body, zbody); err != nil {
+		return nil, err
+	}
+	return &bridge.Service{
+		ID:    zbody.ID,
+		Name:  zbody.Name,
+		IP:    zbody.IP,
+		Port:  zbody.PublicPort,
+		Tags:  zbody.Tags,
+		Attrs: zbody.Attrs,
+	}, nil
+}
+
+// CuratorInstance is the ServiceInstance JSON read by Apache Curator's ServiceDiscovery.
+type CuratorInstance struct {
+	Name                string            `json:"name"`
+	ID                  string            `json:"id"`
+	Address             string            `json:"address"`
+	Port                *int              `json:"port"`
+	SSLPort             *int              `json:"sslPort"`
+	Payload             map[string]string `json:"payload"`
+	RegistrationTimeUTC int64             `json:"registrationTimeUTC"`
+	ServiceType         string            `json:"serviceType"`
+	URISpec             *curatorURISpec   `json:"uriSpec"`
+}
+
+type curatorURISpec struct {
+	Parts []curatorURIPart `json:"parts"`
+}
+
+type curatorURIPart struct {
+	Value    string `json:"value"`
+	Variable bool   `json:"variable"`
+}
+
+// curatorFormat stores a CuratorInstance at <id>. The port is the published
+// one unless SERVICE_SSL_PORT marks it as the TLS port, and attrs become the
+// payload.
+type curatorFormat struct{}
+
+func (curatorFormat) nodeName(service *bridge.Service) string {
+	return service.ID
+}
+
+func (curatorFormat) encode(service *bridge.Service, registeredAt time.Time) ([]byte, error) {
+	port := service.Port
+	instance := &CuratorInstance{
+		Name:                service.Name,
+		ID:                  service.ID,
+		Address:             service.IP,
+		Port:                &port,
+		RegistrationTimeUTC: registeredAt.UnixMilli(),
+		ServiceType:         "DYNAMIC",
+	}
+	if sslPort, err := strconv.Atoi(service.Attrs["ssl_port"]); err == nil {
+		instance.SSLPort = &sslPort
+		if sslPort == service.Port {
+			instance.Port = nil
+		}
+	}
+	if len(service.Attrs) > 0 {
+		instance.Payload = service.Attrs
+	}
+	if spec := service.Attrs["uri_spec"]; spec != "" {
+		instance.URISpec = parseURISpec(spec)
+	}
+	return json.Marshal(instance)
+}
+
+func (curatorFormat) decode(name, node string, body []byte) (*bridge.Service, error) {
+	instance := new(CuratorInstance)
+	if err := json.Unmarshal(body, instance); err != nil {
+		return nil, err
+	}
+	service := &bridge.Service{
+		ID:    instance.ID,
+		Name:  instance.Name,
+		IP:    instance.Address,
+		Attrs: instance.Payload,
+	}
+	if instance.Port != nil {
+		service.Port = *instance.Port
+	} else if instance.SSLPort != nil {
+		service.Port = *instance.SSLPort
+	}
+	if service.ID == "" {
+		service.ID = node
+	}
+	if service.Name == "" {
+		service.Name = name
+	}
+	return service, nil
+}
+
+// parseURISpec splits a Curator URI spec such as "{scheme}://{address}:{port}/api"
+// into its literal and variable parts.
+func parseURISpec(spec string) *curatorURISpec {
+	uriSpec := &curatorURISpec{Parts: []curatorURIPart{}}
+	for len(spec) > 0 {
+		open := strings.Index(spec, "{")
+		if open == -1 {
+			uriSpec.Parts = append(uriSpec.Parts, curatorURIPart{Value: spec})
+			break
+		}
+		if open > 0 {
+			uriSpec.Parts = append(uriSpec.Parts, curatorURIPart{Value: spec[:open]})
+		}
+		end := strings.Index(spec[open:], "}")
+		if end == -1 {
+			uriSpec.Parts = append(uriSpec.Parts, curatorURIPart{Value: spec[open:]})
+			break
+		}
+		uriSpec.Parts = append(uriSpec.Parts, curatorURIPart{Value: spec[open+1 : open+end], Variable: true})
+		spec = spec[open+end+1:]
+	}
+	return uriSpec
+}
+
+// ServersetMember is the Twitter serverset member JSON read by Finagle.
+type ServersetMember struct {
+	ServiceEndpoint     ServersetEndpoint            `json:"serviceEndpoint"`
+	AdditionalEndpoints map[string]ServersetEndpoint `json:"additionalEndpoints"`
+	Status              string                       `json:"status"`
+	Shard               *int                         `json:"shard,omitempty"`
+}
+
+type ServersetEndpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// serversetFormat stores a ServersetMember at member_<id>. Finagle only looks
+// at children with the member_ prefix, so the service ID can live in the name.
+// SERVICE_ENDPOINT_NAME registers the port as a named additional endpoint and
+// SERVICE_SHARD sets the shard id.
+type serversetFormat struct{}
+
+const serversetMemberPrefix = "member_"
+
+func (serversetFormat) nodeName(service *bridge.Service) string {
+	return serversetMemberPrefix + service.ID
+}
+
+func (serversetFormat) encode(service *bridge.Service, _ time.Time) ([]byte, error) {
+	endpoint := ServersetEndpoint{Host: service.IP, Port: service.Port}
+	member := &ServersetMember{
+		ServiceEndpoint:     endpoint,
+		AdditionalEndpoints: map[string]ServersetEndpoint{},
+		Status:              "ALIVE",
+	}
+	if name := service.Attrs["endpoint_name"]; name != "" {
+		member.AdditionalEndpoints[name] = endpoint
+	}
+	if shard, err := strconv.Atoi(service.Attrs["shard"]); err == nil {
+		member.Shard = &shard
+	}
+	return json.Marshal(member)
+}
+
+func (serversetFormat) decode(name, node string, body []byte) (*bridge.Service, error) {
+	if !strings.HasPrefix(node, serversetMemberPrefix) {
+		return nil, errors.New("not a serverset member")
+	}
+	member := new(ServersetMember)
+	if err := json.Unmarshal(body, member); err != nil {
+		return nil, err
+	}
+	return &bridge.Service{
+		ID:   strings.TrimPrefix(node, serversetMemberPrefix),
+		Name: name,
+		IP:   member.ServiceEndpoint.Host,
+		Port: member.ServiceEndpoint.Port,
+	}, nil
+}
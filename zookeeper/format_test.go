@@ -0,0 +1,79 @@
+package zookeeper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+var testService = &bridge.Service{
+	ID:    "host:api:8080",
+	Name:  "api",
+	IP:    "10.0.0.5",
+	Port:  31080,
+	Attrs: map[string]string{"region": "eu"},
+}
+
+func TestCuratorFormat(t *testing.T) {
+	f, err := newFormat("curator")
+	assert.NoError(t, err)
+	assert.Equal(t, "host:api:8080", f.nodeName(testService))
+
+	body, err := f.encode(testService, time.UnixMilli(1700000000000))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"name": "api",
+		"id": "host:api:8080",
+		"address": "10.0.0.5",
+		"port": 31080,
+		"sslPort": null,
+		"payload": {"region": "eu"},
+		"registrationTimeUTC": 1700000000000,
+		"serviceType": "DYNAMIC",
+		"uriSpec": null
+	}`, string(body))
+
+	decoded, err := f.decode("api", "host:api:8080", body)
+	assert.NoError(t, err)
+	assert.Equal(t, testService, decoded)
+}
+
+func TestServersetFormat(t *testing.T) {
+	f, err := newFormat("serverset")
+	assert.NoError(t, err)
+	assert.Equal(t, "member_host:api:8080", f.nodeName(testService))
+
+	body, err := f.encode(testService, time.Now())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"serviceEndpoint": {"host": "10.0.0.5", "port": 31080},
+		"additionalEndpoints": {},
+		"status": "ALIVE"
+	}`, string(body))
+
+	decoded, err := f.decode("api", "member_host:api:8080", body)
+	assert.NoError(t, err)
+	assert.Equal(t, &bridge.Service{ID: "host:api:8080", Name: "api", IP: "10.0.0.5", Port: 31080}, decoded)
+
+	_, err = f.decode("api", "lock", body)
+	assert.Error(t, err)
+}
+
+func TestParseURISpec(t *testing.T) {
+	spec := parseURISpec("{scheme}://{address}:{port}/api")
+	assert.Equal(t, []curatorURIPart{
+		{Value: "scheme", Variable: true},
+		{Value: "://"},
+		{Value: "address", Variable: true},
+		{Value: ":"},
+		{Value: "port", Variable: true},
+		{Value: "/api"},
+	}, spec.Parts)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := newFormat("eureka")
+	assert.Error(t, err)
+}
@@ -1,10 +1,12 @@
 package zookeeper
 
 import (
-	"encoding/json"
+	"errors"
 	log "log/slog"
 	"net/url"
-	"strconv"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/quangnguyen/registrator/bridge"
@@ -18,61 +20,191 @@ func init() {
 type Factory struct{}
 
 func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
-	c, _, err := zk.Connect([]string{uri.Host}, time.Second*10)
+	servers := strings.Split(uri.Host, ",")
+	c, events, err := zk.Connect(servers, time.Second*10)
 	if err != nil {
-		panic(err)
+		log.Error("zookeeper: failed to connect", "servers", servers, "error", err)
+		return &Zookeeper{path: uri.Path, services: make(map[string]*registration)}
 	}
+
+	auth := digestAuth(uri, os.Getenv("ZOOKEEPER_AUTH"))
+	if auth != "" {
+		if err := c.AddAuth("digest", []byte(auth)); err != nil {
+			log.Error("zookeeper: failed to add digest auth", "error", err)
+		}
+	}
+
+	aclMode := uri.Query().Get("acl")
+	if aclMode == "" {
+		aclMode = os.Getenv("ZOOKEEPER_ACL")
+	}
+	acl, err := buildACL(aclMode, auth != "")
+	if err != nil {
+		log.Error("zookeeper: falling back to world ACL", "error", err)
+		acl = zk.WorldACL(zk.PermAll)
+	}
+
 	exists, _, err := c.Exists(uri.Path)
 	if err != nil {
 		log.Error("zookeeper: error checking if base path exists", "error", err)
 	}
 	if !exists {
-		c.Create(uri.Path, []byte{}, 0, zk.WorldACL(zk.PermAll))
+		c.Create(uri.Path, []byte{}, 0, acl)
+	}
+	format, err := newFormat(uri.Query().Get("format"))
+	if err != nil {
+		log.Error("zookeeper: falling back to znode format", "error", err)
+		format = znodeFormat{}
+	}
+
+	r := &Zookeeper{client: c, path: uri.Path, acl: acl, format: format, services: make(map[string]*registration)}
+	go r.watchSession(events)
+	return r
+}
+
+// digestAuth returns the user:password digest credentials from the URI user
+// info, falling back to the ZOOKEEPER_AUTH value.
+func digestAuth(uri *url.URL, env string) string {
+	if uri.User == nil {
+		return env
+	}
+	if password, ok := uri.User.Password(); ok {
+		return uri.User.Username() + ":" + password
 	}
-	return &Zookeeper{client: c, path: uri.Path}
+	return uri.User.Username()
+}
+
+// buildACL maps the acl query parameter to the ACL applied to created znodes.
+// Without a mode, nodes are world-writable unless digest auth is configured,
+// in which case only the authenticated identity may modify them.
+func buildACL(mode string, authenticated bool) ([]zk.ACL, error) {
+	if mode == "" {
+		mode = "world"
+		if authenticated {
+			mode = "creator"
+		}
+	}
+	switch mode {
+	case "world":
+		return zk.WorldACL(zk.PermAll), nil
+	case "creator":
+		if !authenticated {
+			return nil, errors.New("acl 'creator' requires digest auth")
+		}
+		return zk.AuthACL(zk.PermAll), nil
+	case "creator-read":
+		if !authenticated {
+			return nil, errors.New("acl 'creator-read' requires digest auth")
+		}
+		return append(zk.AuthACL(zk.PermAll), zk.WorldACL(zk.PermRead)...), nil
+	}
+	return nil, errors.New("unknown acl '" + mode + "'")
+}
+
+var errNoConnection = errors.New("zookeeper: no connection")
+
+// conn is the part of *zk.Conn the adapter uses.
+type conn interface {
+	Exists(path string) (bool, *zk.Stat, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	Delete(path string, version int32) error
 }
 
 type Zookeeper struct {
-	client *zk.Conn
+	// client is nil when the connection could not be set up.
+	client conn
 	path   string
+	acl    []zk.ACL
+	format format
+
+	sync.Mutex
+	services map[string]*registration
 }
 
-type ZnodeBody struct {
-	Name        string
-	IP          string
-	PublicPort  int
-	PrivatePort int
-	ContainerID string
-	Tags        []string
-	Attrs       map[string]string
+type registration struct {
+	service *bridge.Service
+	since   time.Time
 }
 
-func (r *Zookeeper) Register(service *bridge.Service) error {
-	privatePort, _ := strconv.Atoi(service.Origin.ExposedPort)
-	publicPortString := strconv.Itoa(service.Port)
-	acl := zk.WorldACL(zk.PermAll)
+// watchSession re-creates the ephemeral service znodes once a new session is
+// established, since ZooKeeper drops them all when the previous one expires.
+func (r *Zookeeper) watchSession(events <-chan zk.Event) {
+	expired := false
+	for event := range events {
+		if event.Type != zk.EventSession {
+			continue
+		}
+		switch event.State {
+		case zk.StateExpired:
+			log.Info("zookeeper: session expired, registrations will be restored on reconnect")
+			expired = true
+		case zk.StateHasSession:
+			if !expired {
+				continue
+			}
+			expired = false
+			r.Lock()
+			services := make([]*bridge.Service, 0, len(r.services))
+			for _, reg := range r.services {
+				services = append(services, reg.service)
+			}
+			r.Unlock()
+			log.Info("zookeeper: session re-established, re-registering services", "count", len(services))
+			for _, service := range services {
+				if err := r.Register(service); err != nil {
+					log.Error("zookeeper: failed to re-register service", "serviceID", service.ID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (r *Zookeeper) servicePath(service *bridge.Service) (string, string) {
 	basePath := r.path + "/" + service.Name
 	if r.path == "/" {
 		basePath = r.path + service.Name
 	}
+	return basePath, basePath + "/" + r.format.nodeName(service)
+}
+
+func (r *Zookeeper) Register(service *bridge.Service) error {
+	if r.client == nil {
+		return errNoConnection
+	}
+	basePath, path := r.servicePath(service)
 	exists, _, err := r.client.Exists(basePath)
 	if err != nil {
 		log.Error("zookeeper: error checking if exists", "error", err)
 	} else {
 		if !exists {
-			_, err := r.client.Create(basePath, []byte{}, 0, acl)
+			_, err := r.client.Create(basePath, []byte{}, 0, r.acl)
 			if err != nil {
 				log.Error("zookeeper: failed to create base service node at path '"+basePath+"'", "error", err)
 			}
 		} // create base path for the service name if it missing
-		zbody := &ZnodeBody{Name: service.Name, IP: service.IP, PublicPort: service.Port, PrivatePort: privatePort, Tags: service.Tags, Attrs: service.Attrs, ContainerID: service.Origin.ContainerHostname}
-		body, err := json.Marshal(zbody)
+		registeredAt := time.Now()
+		r.Lock()
+		if reg, ok := r.services[service.ID]; ok {
+			registeredAt = reg.since
+		}
+		r.Unlock()
+		var body []byte
+		body, err = r.format.encode(service, registeredAt)
 		if err != nil {
 			log.Error("zookeeper: failed to json encode service body", "error", err)
 		} else {
-			path := basePath + "/" + service.IP + ":" + publicPortString
-			_, err = r.client.Create(path, body, 1, acl)
-			if err != nil {
+			_, err = r.client.Create(path, body, zk.FlagEphemeral, r.acl)
+			if err == zk.ErrNodeExists {
+				_, err = r.client.Set(path, body, -1)
+			}
+			if err == nil {
+				r.Lock()
+				r.services[service.ID] = &registration{service: service, since: registeredAt}
+				r.Unlock()
+			} else {
 				log.Error("zookeeper: failed to register service at path '"+path+"'", "error", err)
 			} // create service path error check
 		} // json znode body creation check
@@ -81,6 +213,9 @@ func (r *Zookeeper) Register(service *bridge.Service) error {
 }
 
 func (r *Zookeeper) Ping() error {
+	if r.client == nil {
+		return errNoConnection
+	}
 	_, _, err := r.client.Exists("/")
 	if err != nil {
 		log.Error("zookeeper: error on ping check for Exists(/)", "error", err)
@@ -90,12 +225,13 @@ func (r *Zookeeper) Ping() error {
 }
 
 func (r *Zookeeper) Deregister(service *bridge.Service) error {
-	basePath := r.path + "/" + service.Name
-	if r.path == "/" {
-		basePath = r.path + service.Name
+	if r.client == nil {
+		return errNoConnection
 	}
-	publicPortString := strconv.Itoa(service.Port)
-	servicePortPath := basePath + "/" + service.IP + ":" + publicPortString
+	basePath, servicePortPath := r.servicePath(service)
+	r.Lock()
+	delete(r.services, service.ID)
+	r.Unlock()
 	// Delete the service-port znode
 	err := r.client.Delete(servicePortPath, -1) // -1 means latest version number
 	if err != nil {
@@ -118,5 +254,41 @@ func (r *Zookeeper) Refresh(service *bridge.Service) error {
 }
 
 func (r *Zookeeper) Services() ([]*bridge.Service, error) {
-	return []*bridge.Service{}, nil
+	if r.client == nil {
+		return []*bridge.Service{}, errNoConnection
+	}
+	names, _, err := r.client.Children(r.path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return []*bridge.Service{}, nil
+		}
+		return []*bridge.Service{}, err
+	}
+
+	services := make([]*bridge.Service, 0)
+	for _, name := range names {
+		basePath := r.path + "/" + name
+		if r.path == "/" {
+			basePath = r.path + name
+		}
+		entries, _, err := r.client.Children(basePath)
+		if err != nil {
+			log.Error("zookeeper: failed to list service entries at path '"+basePath+"'", "error", err)
+			continue
+		}
+		for _, entry := range entries {
+			body, _, err := r.client.Get(basePath + "/" + entry)
+			if err != nil {
+				log.Error("zookeeper: failed to read service entry '"+basePath+"/"+entry+"'", "error", err)
+				continue
+			}
+			service, err := r.format.decode(name, entry, body)
+			if err != nil {
+				log.Error("zookeeper: failed to decode service body '"+basePath+"/"+entry+"'", "error", err)
+				continue
+			}
+			services = append(services, service)
+		}
+	}
+	return services, nil
 }
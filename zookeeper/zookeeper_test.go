@@ -0,0 +1,237 @@
+package zookeeper
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quangnguyen/registrator/bridge"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn keeps znodes in memory.
+type fakeConn struct {
+	sync.Mutex
+	nodes map[string][]byte
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{nodes: make(map[string][]byte)}
+}
+
+func (c *fakeConn) Exists(path string) (bool, *zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.nodes[path]
+	return ok, nil, nil
+}
+
+func (c *fakeConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.nodes[path]; ok {
+		return "", zk.ErrNodeExists
+	}
+	c.nodes[path] = data
+	return path, nil
+}
+
+func (c *fakeConn) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.nodes[path]; !ok {
+		return nil, zk.ErrNoNode
+	}
+	c.nodes[path] = data
+	return nil, nil
+}
+
+func (c *fakeConn) Get(path string) ([]byte, *zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	data, ok := c.nodes[path]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, nil, nil
+}
+
+func (c *fakeConn) Children(path string) ([]string, *zk.Stat, error) {
+	c.Lock()
+	defer c.Unlock()
+	children := make([]string, 0)
+	for p := range c.nodes {
+		if child, ok := strings.CutPrefix(p, path+"/"); ok && !strings.Contains(child, "/") {
+			children = append(children, child)
+		}
+	}
+	return children, nil, nil
+}
+
+func (c *fakeConn) Delete(path string, version int32) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.nodes[path]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(c.nodes, path)
+	return nil
+}
+
+func (c *fakeConn) expire() {
+	c.Lock()
+	defer c.Unlock()
+	c.nodes = map[string][]byte{"/services": {}}
+}
+
+// failingConn refuses to create ephemeral znodes.
+type failingConn struct {
+	*fakeConn
+}
+
+func (c failingConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	if flags&zk.FlagEphemeral != 0 {
+		return "", zk.ErrNoAuth
+	}
+	return c.fakeConn.Create(path, data, flags, acl)
+}
+
+func newTestZookeeper(c conn) *Zookeeper {
+	return &Zookeeper{
+		client:   c,
+		path:     "/services",
+		acl:      zk.WorldACL(zk.PermAll),
+		format:   znodeFormat{},
+		services: make(map[string]*registration),
+	}
+}
+
+func TestBuildACL(t *testing.T) {
+	tests := []struct {
+		mode          string
+		authenticated bool
+		expected      []zk.ACL
+		err           bool
+	}{
+		{mode: "", authenticated: false, expected: zk.WorldACL(zk.PermAll)},
+		{mode: "", authenticated: true, expected: zk.AuthACL(zk.PermAll)},
+		{mode: "world", authenticated: true, expected: zk.WorldACL(zk.PermAll)},
+		{mode: "creator", authenticated: true, expected: zk.AuthACL(zk.PermAll)},
+		{mode: "creator", authenticated: false, err: true},
+		{mode: "creator-read", authenticated: true, expected: append(zk.AuthACL(zk.PermAll), zk.WorldACL(zk.PermRead)...)},
+		{mode: "creator-read", authenticated: false, err: true},
+		{mode: "ip", authenticated: true, err: true},
+	}
+
+	for _, tt := range tests {
+		acl, err := buildACL(tt.mode, tt.authenticated)
+		if tt.err {
+			assert.Error(t, err, tt.mode)
+			continue
+		}
+		assert.NoError(t, err, tt.mode)
+		assert.Equal(t, tt.expected, acl, tt.mode)
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	tests := []struct {
+		uri      string
+		env      string
+		expected string
+	}{
+		{uri: "zookeeper://zk:2181/services", expected: ""},
+		{uri: "zookeeper://zk:2181/services", env: "env:secret", expected: "env:secret"},
+		{uri: "zookeeper://user:secret@zk:2181/services", env: "env:secret", expected: "user:secret"},
+		{uri: "zookeeper://user%3Asecret@zk:2181/services", expected: "user:secret"},
+		{uri: "zookeeper://user:p%40ss@zk:2181/services", expected: "user:p@ss"},
+	}
+
+	for _, tt := range tests {
+		uri, err := url.Parse(tt.uri)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, digestAuth(uri, tt.env), tt.uri)
+	}
+}
+
+func TestNoConnection(t *testing.T) {
+	r := &Zookeeper{path: "/services", services: make(map[string]*registration)}
+
+	assert.ErrorIs(t, r.Ping(), errNoConnection)
+	assert.ErrorIs(t, r.Register(testService), errNoConnection)
+	assert.ErrorIs(t, r.Refresh(testService), errNoConnection)
+	assert.ErrorIs(t, r.Deregister(testService), errNoConnection)
+	services, err := r.Services()
+	assert.ErrorIs(t, err, errNoConnection)
+	assert.Empty(t, services)
+}
+
+func TestRegisterFails(t *testing.T) {
+	r := newTestZookeeper(failingConn{newFakeConn()})
+
+	assert.ErrorIs(t, r.Register(testService), zk.ErrNoAuth)
+	assert.ErrorIs(t, r.Refresh(testService), zk.ErrNoAuth)
+	assert.Empty(t, r.services)
+}
+
+func TestWatchSessionReregisters(t *testing.T) {
+	c := newFakeConn()
+	r := newTestZookeeper(c)
+	assert.NoError(t, r.Register(testService))
+	_, path := r.servicePath(testService)
+	registered, _, err := c.Get(path)
+	assert.NoError(t, err)
+
+	c.expire()
+	events := make(chan zk.Event, 3)
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateConnecting}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	close(events)
+	r.watchSession(events)
+
+	restored, _, err := c.Get(path)
+	assert.NoError(t, err)
+	assert.Equal(t, registered, restored)
+}
+
+func TestWatchSessionIgnoresReconnect(t *testing.T) {
+	c := newFakeConn()
+	r := newTestZookeeper(c)
+	assert.NoError(t, r.Register(testService))
+	_, path := r.servicePath(testService)
+
+	// without an expiry the ephemeral znodes survive, and a deregistered
+	// service must not come back
+	assert.NoError(t, c.Delete(path, -1))
+	events := make(chan zk.Event, 2)
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateDisconnected}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	close(events)
+	r.watchSession(events)
+
+	exists, _, _ := c.Exists(path)
+	assert.False(t, exists)
+}
+
+func TestDeregisterForgetsService(t *testing.T) {
+	c := newFakeConn()
+	r := newTestZookeeper(c)
+	assert.NoError(t, r.Register(testService))
+	assert.NoError(t, r.Deregister(testService))
+
+	c.expire()
+	events := make(chan zk.Event, 2)
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	close(events)
+	r.watchSession(events)
+
+	services, err := r.Services()
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+var _ bridge.RegistryAdapter = (*Zookeeper)(nil)